@@ -1,9 +1,17 @@
 package cmd
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
 	"github.com/AndresBott/videoconv/app/videoconv"
 	"github.com/AndresBott/videoconv/app/videoconv/config"
+	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"os/signal"
+	"syscall"
 )
 
 func runCmd() *cobra.Command {
@@ -11,6 +19,9 @@ func runCmd() *cobra.Command {
 	configfile := "videoconv.yaml"
 	daemon := false
 	debug := false
+	dryRun := false
+	jsonOut := false
+	stopOnError := false
 
 	cmd := cobra.Command{
 		Use:   "run",
@@ -31,8 +42,43 @@ func runCmd() *cobra.Command {
 				if err != nil {
 					return err
 				}
-				vidConv.Run()
 
+				if dryRun {
+					plans := vidConv.Plan()
+					if jsonOut {
+						b, err := json.MarshalIndent(plans, "", "    ")
+						if err != nil {
+							return err
+						}
+						fmt.Println(string(b))
+						return nil
+					}
+					printPlans(os.Stdout, plans)
+					return nil
+				}
+
+				// stop gracefully on SIGINT/SIGTERM, a second signal terminates immediately
+				ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+				defer stop()
+				go func() {
+					<-ctx.Done()
+					stop()
+				}()
+
+				vidConv.DaemonMode = daemon
+				vidConv.StopOnError = stopOnError
+				result := vidConv.RunContext(ctx)
+
+				log.Infof("%d videos done, %d failed, %d to be retried, %d waiting, %d skipped, %d interrupted",
+					result.Done, result.Failed, result.Retry, result.Waiting, result.Skipped, result.Interrupted)
+				for _, e := range result.Errors {
+					log.Errorf("error: %s", e)
+				}
+				if result.Failed > 0 {
+					// a failed video is not a usage error
+					cmd.SilenceUsage = true
+					return fmt.Errorf("%d videos failed", result.Failed)
+				}
 			}
 			return nil
 		},
@@ -40,6 +86,48 @@ func runCmd() *cobra.Command {
 	cmd.Flags().StringVarP(&configfile, "config", "c", configfile, "configuration file")
 	cmd.Flags().BoolVarP(&daemon, "daemon", "d", daemon, "run in daemon mode")
 	cmd.Flags().BoolVarP(&debug, "verbose", "v", debug, "run in verbose mode")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", dryRun, "print the conversion plan without running ffmpeg or touching any file")
+	cmd.Flags().BoolVar(&jsonOut, "json", jsonOut, "print the dry run plan as json")
+	cmd.Flags().BoolVar(&stopOnError, "stop-on-error", stopOnError, "stop processing once a video fails")
 
 	return &cmd
 }
+
+// printPlans writes a human-readable version of the conversion plan
+func printPlans(w io.Writer, plans []videoconv.VideoPlan) {
+	if len(plans) == 0 {
+		_, _ = fmt.Fprintln(w, "no videos found")
+		return
+	}
+	for _, p := range plans {
+		_, _ = fmt.Fprintf(w, "video: %s (location: %s)\n", p.Video, p.Location)
+		if p.SkipReason != "" {
+			_, _ = fmt.Fprintf(w, "    skipped: %s\n\n", p.SkipReason)
+			continue
+		}
+		if p.Error != "" {
+			_, _ = fmt.Fprintf(w, "    error: %s\n\n", p.Error)
+			continue
+		}
+		for _, r := range p.Renditions {
+			_, _ = fmt.Fprintf(w, "    profile: %s (template: %s)\n", r.Profile, r.Template)
+			_, _ = fmt.Fprintf(w, "        cmd: %s\n", r.Cmd.String())
+			if r.Passes > 0 {
+				_, _ = fmt.Fprintf(w, "        passes: %d, the later ones are rendered after the first\n", r.Passes)
+			}
+			_, _ = fmt.Fprintf(w, "        tmp: %s\n", r.TmpFile)
+			_, _ = fmt.Fprintf(w, "        out: %s\n", r.OutFile)
+		}
+		for _, name := range p.Skipped {
+			_, _ = fmt.Fprintf(w, "    profile: %s skipped, condition is false\n", name)
+		}
+		for _, f := range p.Sidecars {
+			_, _ = fmt.Fprintf(w, "    sidecar: %s\n", f)
+		}
+		if p.SourceOut != "" {
+			_, _ = fmt.Fprintf(w, "    source (%s): %s\n\n", p.SourceAction, p.SourceOut)
+		} else {
+			_, _ = fmt.Fprintf(w, "    source: %s\n\n", p.SourceAction)
+		}
+	}
+}
@@ -3,9 +3,14 @@ package config
 import (
 	"errors"
 	"fmt"
+	"github.com/AndresBott/videoconv/internal/expr"
+	"github.com/AndresBott/videoconv/internal/glob"
+	"github.com/AndresBott/videoconv/internal/resources"
+	"github.com/AndresBott/videoconv/internal/tmpl"
 	"github.com/spf13/viper"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -13,6 +18,7 @@ import (
 const (
 	defaultLogLevel        = "info"
 	defaultSleep           = "5m"
+	defaultWorkers         = 1
 	DefaultFFmpeg          = "/usr/bin/ffmpeg"
 	DefaultFFprobe         = "/usr/bin/ffprobe"
 	DefaultVideoExtensions = "avi,mkv,mov"
@@ -27,11 +33,15 @@ type Conf struct {
 	FfprobePath     string
 	VideoExtensions []string
 	ConfigLocation  string
+	// amount of videos processed in parallel
+	Workers int
 
 	// locations
 	Locations []Location
 	// templates
 	TmplDirs []string
+	// named resource pools that profiles can lease, e.g. GPUs
+	Resources map[string][]resources.Item
 }
 
 func NewFromFile(configFile string) (Conf, error) {
@@ -65,12 +75,30 @@ func (cfg *Conf) loadFile(configFile string) error {
 		return err
 	}
 
+	// load resource pools
+	cfg.Resources, err = resourceSettings(v)
+	if err != nil {
+		return err
+	}
+
 	// load video locations
 	cfg.Locations, err = locationSettings(v)
 	if err != nil {
 		return err
 	}
 
+	// verify that profiles only use defined resources
+	for _, loc := range cfg.Locations {
+		for _, pr := range loc.Profiles {
+			if pr.Resource == "" {
+				continue
+			}
+			if _, ok := cfg.Resources[pr.Resource]; !ok {
+				return fmt.Errorf("profile \"%s\" uses undefined resource pool \"%s\"", pr.Name, pr.Resource)
+			}
+		}
+	}
+
 	return nil
 }
 
@@ -93,6 +121,15 @@ func (cfg *Conf) systemSettings(v *viper.Viper) error {
 	}
 	cfg.Sleep = sleep
 
+	// parallel workers
+	cfg.Workers = v.GetInt("workers")
+	if cfg.Workers == 0 {
+		cfg.Workers = defaultWorkers
+	}
+	if cfg.Workers < 0 {
+		return fmt.Errorf("workers must be a positive number, got: %d", cfg.Workers)
+	}
+
 	// ffmpeg
 	cfg.FfmpegPath = v.GetString("ffmpeg")
 	if cfg.FfmpegPath == "" {
@@ -126,22 +163,349 @@ func (cfg *Conf) systemSettings(v *viper.Viper) error {
 	return nil
 }
 
+// resourceSettings loads the named resource pools, every pool is a list of items that are either
+// a plain string (used as name and device) or a map with the keys name, device and capacity
+func resourceSettings(v *viper.Viper) (map[string][]resources.Item, error) {
+	confResources := v.Get("resources")
+	if confResources == nil {
+		return nil, nil
+	}
+
+	pools, ok := toStringMap(confResources)
+	if !ok {
+		return nil, errors.New("resources must be a map of resource pools")
+	}
+
+	out := map[string][]resources.Item{}
+	for pool, items := range pools {
+		itemList, ok := items.([]interface{})
+		if !ok || len(itemList) == 0 {
+			return nil, fmt.Errorf("resource pool \"%s\" must be a non empty list", pool)
+		}
+
+		for _, i := range itemList {
+			item := resources.Item{
+				Capacity: 1,
+			}
+			switch i := i.(type) {
+			case string:
+				item.Name = i
+				item.Device = i
+			default:
+				values, ok := toStringMap(i)
+				if !ok {
+					return nil, fmt.Errorf("unsupported item in resource pool \"%s\": %v", pool, i)
+				}
+				for k, v := range values {
+					switch k {
+					case "name":
+						item.Name = fmt.Sprintf("%v", v)
+					case "device":
+						item.Device = fmt.Sprintf("%v", v)
+					case "capacity":
+						c, ok := v.(int)
+						if !ok || c < 1 {
+							return nil, fmt.Errorf("capacity of resource in pool \"%s\" must be a positive number, got: %v", pool, v)
+						}
+						item.Capacity = c
+					}
+				}
+				if item.Name == "" {
+					item.Name = item.Device
+				}
+				if item.Device == "" {
+					item.Device = item.Name
+				}
+				if item.Name == "" {
+					return nil, fmt.Errorf("resource in pool \"%s\" needs a name or a device", pool)
+				}
+			}
+			out[pool] = append(out[pool], item)
+		}
+	}
+	return out, nil
+}
+
+// toStringMap converts the different map types returned by the yaml parser into a map with string keys
+func toStringMap(in interface{}) (map[string]interface{}, bool) {
+	switch in := in.(type) {
+	case map[string]interface{}:
+		return in, true
+	case map[interface{}]interface{}:
+		out := map[string]interface{}{}
+		for k, v := range in {
+			out[fmt.Sprintf("%v", k)] = v
+		}
+		return out, true
+	}
+	return nil, false
+}
+
+// toStringSlice converts a yaml list into a slice of strings
+func toStringSlice(in interface{}) ([]string, bool) {
+	list, ok := in.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	out := []string{}
+	for _, i := range list {
+		out = append(out, fmt.Sprintf("%v", i))
+	}
+	return out, true
+}
+
+// toDuration converts a yaml value like "30s" into a duration
+func toDuration(in interface{}) (time.Duration, error) {
+	switch in := in.(type) {
+	case int:
+		if in == 0 {
+			return 0, nil
+		}
+	case string:
+		return time.ParseDuration(in)
+	}
+	return 0, fmt.Errorf("unable to parse duration: %v", in)
+}
+
+// toSize converts a yaml value like 1024, "500K", "10M" or "1G" into bytes
+func toSize(in interface{}) (int64, error) {
+	switch in := in.(type) {
+	case int:
+		if in >= 0 {
+			return int64(in), nil
+		}
+	case string:
+		units := map[string]int64{"K": 1 << 10, "M": 1 << 20, "G": 1 << 30}
+		s := strings.ToUpper(strings.TrimSpace(in))
+		mult := int64(1)
+		if len(s) > 1 {
+			if u, ok := units[s[len(s)-1:]]; ok {
+				mult = u
+				s = s[:len(s)-1]
+			}
+		}
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err == nil && n >= 0 {
+			return n * mult, nil
+		}
+	}
+	return 0, fmt.Errorf("unable to parse size: %v", in)
+}
+
+// error classes used to decide which failures are retried
+const (
+	ErrClassProbe    = "probe"    // ffprobe was not able to read the video
+	ErrClassTemplate = "template" // the template was not found or could not be rendered
+	ErrClassFfmpeg   = "ffmpeg"   // ffmpeg exited with an error, e.g. busy GPU or full disk
+	ErrClassIO       = "io"       // filesystem errors while preparing or moving files
+	ErrClassConflict = "conflict" // an output file already exists and on_conflict is fail
+)
+
+var errClasses = []string{ErrClassProbe, ErrClassTemplate, ErrClassFfmpeg, ErrClassIO, ErrClassConflict}
+
+// RetryPolicy defines how often a failed video is tried again before it is moved to the fail dir
+type RetryPolicy struct {
+	// total amount of attempts, 1 means no retry
+	MaxAttempts int
+	// wait time before the first retry, doubled on every further attempt up to MaxBackoff,
+	// both are bigger than 0
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// error classes that are retried
+	RetryOn []string
+}
+
+// DefaultRetryPolicy does not retry failed videos
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: 1,
+		Backoff:     time.Minute,
+		MaxBackoff:  time.Hour,
+		RetryOn:     []string{ErrClassProbe, ErrClassFfmpeg, ErrClassIO},
+	}
+}
+
+// buildRetry parses a retry policy, values not present are taken from the base policy
+func buildRetry(in interface{}, base RetryPolicy) (RetryPolicy, error) {
+	values, ok := toStringMap(in)
+	if !ok {
+		return base, fmt.Errorf("retry must be a map, got: %v", in)
+	}
+	policy := base
+	for k, v := range values {
+		switch k {
+		case "max_attempts":
+			n, ok := v.(int)
+			if !ok || n < 1 {
+				return base, fmt.Errorf("retry max_attempts must be a number bigger than 0, got: %v", v)
+			}
+			policy.MaxAttempts = n
+		case "backoff":
+			d, err := toDuration(v)
+			if err != nil {
+				return base, fmt.Errorf("retry backoff: %v", err)
+			}
+			if d <= 0 {
+				return base, fmt.Errorf("retry backoff must be bigger than 0, got: %v", v)
+			}
+			policy.Backoff = d
+		case "max_backoff":
+			d, err := toDuration(v)
+			if err != nil {
+				return base, fmt.Errorf("retry max_backoff: %v", err)
+			}
+			if d <= 0 {
+				return base, fmt.Errorf("retry max_backoff must be bigger than 0, got: %v", v)
+			}
+			policy.MaxBackoff = d
+		case "retry_on":
+			classes, ok := toStringSlice(v)
+			if !ok {
+				return base, fmt.Errorf("retry retry_on must be a list, got: %v", v)
+			}
+			for _, c := range classes {
+				if !contains(errClasses, c) {
+					return base, fmt.Errorf("unknown error class \"%s\" in retry_on, allowed: %s", c, strings.Join(errClasses, ", "))
+				}
+			}
+			policy.RetryOn = classes
+		default:
+			return base, fmt.Errorf("unknown retry setting: %s", k)
+		}
+	}
+	if policy.MaxBackoff < policy.Backoff {
+		return base, fmt.Errorf("retry max_backoff %s is smaller than backoff %s", policy.MaxBackoff, policy.Backoff)
+	}
+	return policy, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, i := range list {
+		if i == s {
+			return true
+		}
+	}
+	return false
+}
+
 type Location struct {
 	Path      string
 	InputDir  string
 	OutputDir string
 	TmpDir    string
 	FailDir   string
-	Profiles  []Profile
+	// max amount of videos of this location processed in parallel, 0 means only limited by the global workers
+	Workers int
+	// a video is only processed once its size and mtime have not changed for this time
+	SettleTime time.Duration
+	// a video is skipped while a file with the same name plus one of these suffixes exists, e.g. movie.mkv.part
+	UploadSuffixes []string
+	// skip videos that are locked by another process
+	ExclusiveCheck bool
+	// extensions of the videos in this location, nil uses the global video extensions
+	VideoExtensions []string
+	// glob patterns matched against the path relative to the input dir, e.g. "**/sample/**" or "*.trailer.*"
+	Include []string
+	Exclude []string
+	// skip files and directories starting with a dot
+	SkipHidden bool
+	// files smaller than this amount of bytes are ignored
+	MinSize        int64
+	FollowSymlinks bool
+	// how media files are recognized, by extension or by content
+	Detect string
+	// glob patterns of the files that belong to a video and are moved along with it, e.g. "{name}.*",
+	// {name} is replaced by the name of the video without extension
+	Sidecars []string
+	// retry policy of failed videos, profiles can override it
+	Retry RetryPolicy
+	// what happens with the successful renditions when one profile fails
+	PartialFailure string
+	// transcode the profiles with the same init args in a single ffmpeg run, decoding the video only once
+	SingleDecode bool
+	// what happens when a file with the same name already exists in the output directory
+	OnConflict string
+	// what happens with the source video once all renditions are published
+	SourceAction string
+	ArchiveDir   string
+	TrashDir     string
+	// files in the trash dir are deleted after this time
+	TrashRetention time.Duration
+	// what happens with the files of the input dir that are not videos
+	Leftovers string
+	// leftovers are only moved or deleted once they have not been modified for this time
+	LeftoverGrace time.Duration
+	// delete empty directories of the input dir
+	PruneEmptyDirs bool
+	Profiles       []Profile
 }
 
 const (
-	DefaultInputDir  = "in"
-	DefaultOutputDir = "out"
-	DefaultTmpDir    = "tmp"
-	DefaultFailDir   = "fail"
+	DefaultInputDir       = "in"
+	DefaultOutputDir      = "out"
+	DefaultTmpDir         = "tmp"
+	DefaultFailDir        = "fail"
+	DefaultArchiveDir     = "archive"
+	DefaultTrashDir       = "trash"
+	DefaultTrashRetention = 30 * 24 * time.Hour
+	DefaultLeftoverGrace  = 24 * time.Hour
+)
+
+// partial failure policies
+const (
+	PartialAllOrNothing      = "all-or-nothing"     // stop at the first failing profile and publish nothing
+	PartialPublishSuccessful = "publish-successful" // run all profiles and publish the ones that succeeded
+	PartialContinueOthers    = "continue-others"    // run all profiles but only publish once all succeeded
 )
 
+var partialFailurePolicies = []string{PartialAllOrNothing, PartialPublishSuccessful, PartialContinueOthers}
+
+// conflict policies for files that already exist in the output directory
+const (
+	ConflictOverwrite   = "overwrite"    // replace the existing file
+	ConflictSkip        = "skip"         // keep the existing file and discard the new one
+	ConflictFail        = "fail"         // fail the video without publishing anything
+	ConflictRename      = "rename"       // add a counter to the name of the new file
+	ConflictKeepLarger  = "keep-larger"  // keep the bigger of both files
+	ConflictKeepSmaller = "keep-smaller" // keep the smaller of both files
+)
+
+// actions for the source video after success
+const (
+	SourceMoveToOut = "move-to-out"          // move the source next to the renditions
+	SourceArchive   = "archive"              // move the source into the archive dir
+	SourceHardlink  = "hardlink"             // hardlink the source into the archive dir and keep it in place
+	SourceDelete    = "delete"               // delete the source
+	SourceKeep      = "keep"                 // leave the source in the input dir, it is not processed again
+	SourceTrash     = "trash-with-retention" // move the source into the trash dir, where it is deleted after the retention time
+)
+
+// detection modes of media files
+const (
+	DetectExtension = "extension" // files with one of the video extensions
+	DetectContent   = "content"   // files whose content contains a video stream, regardless of the extension
+)
+
+// SidecarName is replaced by the name of the video without extension in the sidecar patterns
+const SidecarName = "{name}"
+
+var detectModes = []string{DetectExtension, DetectContent}
+
+// policies for files in the input dir that are not videos
+const (
+	LeftoverIgnore        = "ignore"          // leave the files in the input dir
+	LeftoverMoveWithVideo = "move-with-video" // move the files named after a video along with it
+	LeftoverMoveToOut     = "move-to-out"     // move the files into the output dir after the grace time
+	LeftoverMoveToFail    = "move-to-fail"    // move the files into the fail dir after the grace time
+	LeftoverDelete        = "delete"          // delete the files after the grace time
+)
+
+var leftoverPolicies = []string{LeftoverIgnore, LeftoverMoveWithVideo, LeftoverMoveToOut, LeftoverMoveToFail, LeftoverDelete}
+
+var sourceActions = []string{SourceMoveToOut, SourceArchive, SourceHardlink, SourceDelete, SourceKeep, SourceTrash}
+
+var conflictPolicies = []string{ConflictOverwrite, ConflictSkip, ConflictFail, ConflictRename, ConflictKeepLarger, ConflictKeepSmaller}
+
 func locationSettings(viper *viper.Viper) ([]Location, error) {
 	confLocations := viper.Get("locations")
 	if confLocations == nil {
@@ -172,6 +536,30 @@ func buildLocation(in interface{}) (Location, error) {
 		OutputDir: DefaultOutputDir,
 		TmpDir:    DefaultTmpDir,
 		FailDir:   DefaultFailDir,
+		Retry:     DefaultRetryPolicy(),
+
+		PartialFailure: PartialAllOrNothing,
+		OnConflict:     ConflictOverwrite,
+
+		SourceAction:   SourceMoveToOut,
+		ArchiveDir:     DefaultArchiveDir,
+		TrashDir:       DefaultTrashDir,
+		TrashRetention: DefaultTrashRetention,
+
+		Leftovers:     LeftoverIgnore,
+		LeftoverGrace: DefaultLeftoverGrace,
+
+		SkipHidden: true,
+		Detect:     DetectExtension,
+	}
+
+	// the retry policy is needed as base for the profiles
+	if v, ok := in.(map[interface{}]interface{})["retry"]; ok {
+		policy, err := buildRetry(v, loc.Retry)
+		if err != nil {
+			return Location{}, err
+		}
+		loc.Retry = policy
 	}
 
 	for k, v := range in.(map[interface{}]interface{}) {
@@ -197,6 +585,180 @@ func buildLocation(in interface{}) (Location, error) {
 			loc.FailDir = fmt.Sprintf("%s", v)
 			continue
 
+		case "workers":
+			workers, ok := v.(int)
+			if !ok || workers < 0 {
+				return Location{}, fmt.Errorf("location workers must be a positive number, got: %v", v)
+			}
+			loc.Workers = workers
+			continue
+
+		case "settle_time":
+			d, err := toDuration(v)
+			if err != nil {
+				return Location{}, fmt.Errorf("location settle_time: %v", err)
+			}
+			loc.SettleTime = d
+			continue
+
+		case "upload_suffixes":
+			suffixes, ok := toStringSlice(v)
+			if !ok {
+				return Location{}, fmt.Errorf("location upload_suffixes must be a list, got: %v", v)
+			}
+			loc.UploadSuffixes = suffixes
+			continue
+
+		case "exclusive_check":
+			b, ok := v.(bool)
+			if !ok {
+				return Location{}, fmt.Errorf("location exclusive_check must be true or false, got: %v", v)
+			}
+			loc.ExclusiveCheck = b
+			continue
+
+		case "video_extensions":
+			extensions, ok := toStringSlice(v)
+			if !ok {
+				return Location{}, fmt.Errorf("location video_extensions must be a list, got: %v", v)
+			}
+			loc.VideoExtensions = extensions
+			continue
+
+		case "include", "exclude":
+			patterns, ok := toStringSlice(v)
+			if !ok {
+				return Location{}, fmt.Errorf("location %s must be a list, got: %v", k, v)
+			}
+			for _, p := range patterns {
+				if !glob.Valid(p) {
+					return Location{}, fmt.Errorf("invalid %s pattern: %s", k, p)
+				}
+			}
+			if k == "include" {
+				loc.Include = patterns
+			} else {
+				loc.Exclude = patterns
+			}
+			continue
+
+		case "skip_hidden":
+			b, ok := v.(bool)
+			if !ok {
+				return Location{}, fmt.Errorf("location skip_hidden must be true or false, got: %v", v)
+			}
+			loc.SkipHidden = b
+			continue
+
+		case "min_size":
+			size, err := toSize(v)
+			if err != nil {
+				return Location{}, fmt.Errorf("location min_size: %v", err)
+			}
+			loc.MinSize = size
+			continue
+
+		case "follow_symlinks":
+			b, ok := v.(bool)
+			if !ok {
+				return Location{}, fmt.Errorf("location follow_symlinks must be true or false, got: %v", v)
+			}
+			loc.FollowSymlinks = b
+			continue
+
+		case "detect":
+			mode := fmt.Sprintf("%s", v)
+			if !contains(detectModes, mode) {
+				return Location{}, fmt.Errorf("unknown detect mode \"%s\", allowed: %s", mode, strings.Join(detectModes, ", "))
+			}
+			loc.Detect = mode
+			continue
+
+		case "sidecars":
+			patterns, ok := toStringSlice(v)
+			if !ok {
+				return Location{}, fmt.Errorf("location sidecars must be a list, got: %v", v)
+			}
+			for _, p := range patterns {
+				if strings.Contains(p, "/") || !glob.Valid(strings.ReplaceAll(p, SidecarName, "name")) {
+					return Location{}, fmt.Errorf("invalid sidecars pattern: %s", p)
+				}
+			}
+			loc.Sidecars = patterns
+			continue
+
+		case "single_decode":
+			b, ok := v.(bool)
+			if !ok {
+				return Location{}, fmt.Errorf("location single_decode must be true or false, got: %v", v)
+			}
+			loc.SingleDecode = b
+			continue
+
+		case "partial_failure":
+			policy := fmt.Sprintf("%s", v)
+			if !contains(partialFailurePolicies, policy) {
+				return Location{}, fmt.Errorf("unknown partial_failure \"%s\", allowed: %s", policy, strings.Join(partialFailurePolicies, ", "))
+			}
+			loc.PartialFailure = policy
+			continue
+
+		case "on_conflict":
+			policy := fmt.Sprintf("%s", v)
+			if !contains(conflictPolicies, policy) {
+				return Location{}, fmt.Errorf("unknown on_conflict \"%s\", allowed: %s", policy, strings.Join(conflictPolicies, ", "))
+			}
+			loc.OnConflict = policy
+			continue
+
+		case "source_action":
+			action := fmt.Sprintf("%s", v)
+			if !contains(sourceActions, action) {
+				return Location{}, fmt.Errorf("unknown source_action \"%s\", allowed: %s", action, strings.Join(sourceActions, ", "))
+			}
+			loc.SourceAction = action
+			continue
+
+		case "archive":
+			loc.ArchiveDir = fmt.Sprintf("%s", v)
+			continue
+
+		case "trash":
+			loc.TrashDir = fmt.Sprintf("%s", v)
+			continue
+
+		case "trash_retention":
+			d, err := toDuration(v)
+			if err != nil {
+				return Location{}, fmt.Errorf("location trash_retention: %v", err)
+			}
+			loc.TrashRetention = d
+			continue
+
+		case "leftovers":
+			policy := fmt.Sprintf("%s", v)
+			if !contains(leftoverPolicies, policy) {
+				return Location{}, fmt.Errorf("unknown leftovers policy \"%s\", allowed: %s", policy, strings.Join(leftoverPolicies, ", "))
+			}
+			loc.Leftovers = policy
+			continue
+
+		case "leftover_grace":
+			d, err := toDuration(v)
+			if err != nil {
+				return Location{}, fmt.Errorf("location leftover_grace: %v", err)
+			}
+			loc.LeftoverGrace = d
+			continue
+
+		case "prune_empty_dirs":
+			b, ok := v.(bool)
+			if !ok {
+				return Location{}, fmt.Errorf("location prune_empty_dirs must be true or false, got: %v", v)
+			}
+			loc.PruneEmptyDirs = b
+			continue
+
 		case "profiles":
 			profileList := v.([]interface{})
 			if len(profileList) == 0 {
@@ -204,7 +766,7 @@ func buildLocation(in interface{}) (Location, error) {
 			}
 			for _, i := range profileList {
 
-				got, err := buildProfile(i)
+				got, err := buildProfile(i, loc.Retry)
 				if err != nil {
 					return Location{}, err
 				}
@@ -227,16 +789,36 @@ func buildLocation(in interface{}) (Location, error) {
 type Profile struct {
 	Name     string
 	Template string
-	Args     map[string]string
+	// name of the resource pool a slot is leased from while the profile runs
+	Resource string
+	// retry policy for failures of this profile, nil uses the policy of the location
+	Retry *RetryPolicy
+	// expression evaluated against the probe data, the profile is skipped if it is false
+	When string
+	// go template of the name of the rendition, without extension
+	OutputName string
+	// go template of the directory the rendition is published to, relative to the location or absolute
+	OutputDir string
+	Args      map[string]string
 }
 
-func buildProfile(in interface{}) (Profile, error) {
+// buildProfile parses a profile, the retry policy of the location is used as base for the profile one
+func buildProfile(in interface{}, locationRetry RetryPolicy) (Profile, error) {
 	pr := Profile{
 		Args: map[string]string{},
 	}
 	// iterate over the profile entry
 	for k, v := range in.(map[interface{}]interface{}) {
 
+		if k == "retry" {
+			policy, err := buildRetry(v, locationRetry)
+			if err != nil {
+				return Profile{}, err
+			}
+			pr.Retry = &policy
+			continue
+		}
+
 		// stringify the value
 		value := ""
 		switch v := v.(type) {
@@ -254,6 +836,27 @@ func buildProfile(in interface{}) (Profile, error) {
 		case "name":
 			pr.Name = value
 			continue
+		case "resource":
+			pr.Resource = value
+			continue
+		case "when":
+			if _, err := expr.Compile(value); err != nil {
+				return Profile{}, fmt.Errorf("profile when: %v", err)
+			}
+			pr.When = value
+			continue
+		case "output_name":
+			if err := tmpl.Check(value); err != nil {
+				return Profile{}, fmt.Errorf("profile output_name: %v", err)
+			}
+			pr.OutputName = value
+			continue
+		case "output_dir":
+			if err := tmpl.Check(value); err != nil {
+				return Profile{}, fmt.Errorf("profile output_dir: %v", err)
+			}
+			pr.OutputDir = value
+			continue
 		default:
 			pr.Args[k.(string)] = value
 		}
@@ -267,13 +870,17 @@ func SampleCfg() string {
 	return `# sample configuration file for videconv
 log_level: "info"
 
-# poll interval looking for new videos if running in daemon mode
+# in daemon mode new files are detected through filesystem events,
+# this poll interval is used as fallback on filesystems that don't send events
 poll_interval: "5m"
 
 # if ffmpeg is in a differnt location
 ffmpeg:  "/usr/bin/ffmpeg"
 ffprobe: "/usr/bin/ffprobe"
 
+# amount of videos processed in parallel
+workers: 1
+
 # only files with this extensions are processed
 video_extensions:
   - avi
@@ -289,15 +896,57 @@ locations:
     output: "out"   # output where processed videos are moved
     tmp:    "tmp"   # temporary directory while processing a video
     fail:   "fail"  # failed videos are moved here
+    workers: 0      # max parallel videos in this location, 0 means only limited by the global workers
+    # settle_time: "1m"  # only process videos whose size and mtime have not changed for this time
+    # upload_suffixes: [".part", ".tmp"]  # skip movie.mkv while movie.mkv.part exists
+    # exclusive_check: true  # skip videos locked by another process, e.g. while written over SMB
+    # video_extensions: [mkv, mp4]  # overrides the global video extensions for this location
+    # include: ["movies/**"]        # only process videos whose path relative to the input dir matches
+    # exclude: ["**/@eaDir/**", "*.trailer.*"]  # skip videos whose relative path matches, "**" matches any directories
+    skip_hidden: true     # skip files and directories starting with a dot, e.g. .Trash
+    # min_size: "1M"      # ignore smaller files, accepts bytes or K, M, G
+    follow_symlinks: false
+    detect: "extension"   # extension, or content to probe files without a known extension by their content
+    # sidecars: ["{name}.*", "{name}-*"]  # files moved along with the video and available to the templates, {name} is the video name without extension
+    partial_failure: "all-or-nothing"  # when a profile fails: all-or-nothing, publish-successful or continue-others
+    single_decode: false  # run the profiles with the same init args as one ffmpeg command with several outputs
+    on_conflict: "overwrite"  # existing output files: overwrite, skip, fail, rename, keep-larger or keep-smaller
+    source_action: "move-to-out"  # after success: move-to-out, archive, hardlink, delete, keep or trash-with-retention
+    archive: "archive"            # used by archive and hardlink
+    trash:   "trash"              # used by trash-with-retention, files are deleted after trash_retention
+    trash_retention: "720h"
+    leftovers: "ignore"      # other files: ignore, move-with-video, move-to-out, move-to-fail or delete
+    leftover_grace: "24h"    # move-to-out, move-to-fail and delete wait until the files are unchanged for this time
+    prune_empty_dirs: false  # delete empty directories of the input dir unchanged for leftover_grace
+    retry:                 # failed videos stay in the input dir until all attempts are used
+      max_attempts: 1      # 1 means no retry
+      backoff: "1m"        # wait time before the first retry, doubled on every attempt
+      max_backoff: "1h"    # upper limit of the wait time, at least as big as backoff
+      retry_on:            # error classes to retry: probe, template, ffmpeg, io, conflict
+        - probe
+        - ffmpeg
+        - io
     profiles:
       - name: sample 
         template: "sample"
+        # when: 'height >= 1080 && codec != "hevc"'  # only run the profile if the expression is true
+        # output_name: '{{ .File.BaseName }} [{{ .Video.Summary.Video.H }}p]'  # name of the rendition without extension
+        # output_dir: 'out/{{ .Profile.Name }}'  # relative to the location or absolute, defaults to the output dir
         key: "value"
 
 template_dirs:
   - /etc/videconv/templates
   - ./templates
 
+# named resource pools, a profile with "resource: <pool>" leases one slot of the pool while running,
+# the leased item is available in the template as .Resource.Name and .Resource.Device
+#resources:
+#  gpu:
+#    - /dev/dri/renderD128
+#    - name: renderD129
+#      device: /dev/dri/renderD129
+#      capacity: 2  # amount of jobs that can use this item at the same time
+
 `
 
 }
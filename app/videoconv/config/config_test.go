@@ -1,6 +1,7 @@
 package config
 
 import (
+	"github.com/AndresBott/videoconv/internal/resources"
 	"github.com/davecgh/go-spew/spew"
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
@@ -28,6 +29,7 @@ func TestLoadConfig(t *testing.T) {
 				FfmpegPath:      "/usr/bin/ffmpeg",
 				FfprobePath:     "/usr/bin/ffprobe",
 				VideoExtensions: []string{"avi", "mkv", "mov"},
+				Workers:         1,
 				Locations: []Location{
 					{
 						Path:      "./",
@@ -35,7 +37,20 @@ func TestLoadConfig(t *testing.T) {
 						OutputDir: "out",
 						TmpDir:    "tmp",
 						FailDir:   "fail",
-						Profiles:  nil,
+						Retry:     DefaultRetryPolicy(),
+
+						SkipHidden: true,
+						Detect:     DetectExtension,
+
+						PartialFailure: PartialAllOrNothing,
+						OnConflict:     ConflictOverwrite,
+						SourceAction:   SourceMoveToOut,
+						ArchiveDir:     DefaultArchiveDir,
+						TrashDir:       DefaultTrashDir,
+						TrashRetention: DefaultTrashRetention,
+						Leftovers:      LeftoverIgnore,
+						LeftoverGrace:  DefaultLeftoverGrace,
+						Profiles:       nil,
 					},
 				},
 				TmplDirs: []string{
@@ -53,6 +68,7 @@ func TestLoadConfig(t *testing.T) {
 				FfmpegPath:      "/usr/local/bin/ffmpeg-static",
 				FfprobePath:     "/usr/local/bin/ffprobe-static",
 				VideoExtensions: []string{"mkv"},
+				Workers:         4,
 				Locations: []Location{
 					{
 						Path:      "./",
@@ -60,6 +76,19 @@ func TestLoadConfig(t *testing.T) {
 						OutputDir: "out",
 						TmpDir:    "tmp",
 						FailDir:   "fail",
+						Retry:     DefaultRetryPolicy(),
+
+						SkipHidden: true,
+						Detect:     DetectExtension,
+
+						PartialFailure: PartialAllOrNothing,
+						OnConflict:     ConflictOverwrite,
+						SourceAction:   SourceMoveToOut,
+						ArchiveDir:     DefaultArchiveDir,
+						TrashDir:       DefaultTrashDir,
+						TrashRetention: DefaultTrashRetention,
+						Leftovers:      LeftoverIgnore,
+						LeftoverGrace:  DefaultLeftoverGrace,
 						Profiles: []Profile{
 							{
 								Template: "mp4-x265aac",
@@ -69,26 +98,69 @@ func TestLoadConfig(t *testing.T) {
 								},
 							},
 							{
-								Template: "test",
+								Template:   "test",
+								Resource:   "gpu",
+								When:       `height >= 2160 && codec != "hevc"`,
+								OutputName: `{{ .File.BaseName }} [{{ .Video.Summary.Video.H }}p]`,
+								OutputDir:  `/srv/media/{{ .Video.Summary.Video.H }}p`,
 								Args: map[string]string{
 									"key": "value",
 								},
+								Retry: &RetryPolicy{
+									MaxAttempts: 5,
+									Backoff:     time.Minute,
+									MaxBackoff:  time.Hour,
+									RetryOn:     []string{ErrClassProbe, ErrClassFfmpeg, ErrClassIO},
+								},
 							},
 						},
 					},
 					{
-						Path:      "./some_path",
-						InputDir:  "input",
-						OutputDir: "output",
-						TmpDir:    "temp",
-						FailDir:   "error",
-						Profiles:  nil,
+						Path:            "./some_path",
+						InputDir:        "input",
+						OutputDir:       "output",
+						TmpDir:          "temp",
+						FailDir:         "error",
+						Workers:         2,
+						SettleTime:      30 * time.Second,
+						UploadSuffixes:  []string{".part"},
+						VideoExtensions: []string{"mp4"},
+						Include:         []string{"movies/**"},
+						Exclude:         []string{"**/sample/**", "*.trailer.*"},
+						SkipHidden:      false,
+						MinSize:         10 << 20,
+						FollowSymlinks:  true,
+						Detect:          DetectContent,
+						Sidecars:        []string{"{name}.*", "poster.jpg"},
+						Retry: RetryPolicy{
+							MaxAttempts: 3,
+							Backoff:     30 * time.Second,
+							MaxBackoff:  time.Hour,
+							RetryOn:     []string{ErrClassFfmpeg},
+						},
+						PartialFailure: PartialPublishSuccessful,
+						SingleDecode:   true,
+						OnConflict:     ConflictRename,
+						SourceAction:   SourceTrash,
+						ArchiveDir:     DefaultArchiveDir,
+						TrashDir:       "deleted",
+						TrashRetention: 48 * time.Hour,
+						Leftovers:      LeftoverMoveWithVideo,
+						LeftoverGrace:  time.Hour,
+						PruneEmptyDirs: true,
+						Profiles:       nil,
 					},
 				},
 				TmplDirs: []string{
 					"/etc/videconv/templates",
 					"./sample/templates",
 				},
+				Resources: map[string][]resources.Item{
+					"gpu": {
+						{Name: "/dev/dri/renderD128", Device: "/dev/dri/renderD128", Capacity: 1},
+						{Name: "second", Device: "/dev/dri/renderD129", Capacity: 2},
+					},
+				},
 			},
 		},
 	}
@@ -126,14 +198,26 @@ func TestGeneratedConfig(t *testing.T) {
 		FfmpegPath:      "/usr/bin/ffmpeg",
 		FfprobePath:     "/usr/bin/ffprobe",
 		VideoExtensions: []string{"avi", "mkv", "mov", "wmv", "mp4"},
+		Workers:         1,
 		ConfigLocation:  cfgFile,
 		Locations: []Location{
 			{
-				Path:      "./sample",
-				InputDir:  "in",
-				OutputDir: "out",
-				TmpDir:    "tmp",
-				FailDir:   "fail",
+				Path:           "./sample",
+				InputDir:       "in",
+				OutputDir:      "out",
+				TmpDir:         "tmp",
+				FailDir:        "fail",
+				SkipHidden:     true,
+				Detect:         DetectExtension,
+				Retry:          DefaultRetryPolicy(),
+				PartialFailure: PartialAllOrNothing,
+				OnConflict:     ConflictOverwrite,
+				SourceAction:   SourceMoveToOut,
+				ArchiveDir:     DefaultArchiveDir,
+				TrashDir:       DefaultTrashDir,
+				TrashRetention: DefaultTrashRetention,
+				Leftovers:      LeftoverIgnore,
+				LeftoverGrace:  DefaultLeftoverGrace,
 				Profiles: []Profile{
 					{
 						Name:     "sample",
@@ -155,3 +239,44 @@ func TestGeneratedConfig(t *testing.T) {
 		t.Errorf("unexpected value (-got +want)\n%s", diff)
 	}
 }
+
+func TestBuildRetry(t *testing.T) {
+	tcs := []struct {
+		name string
+		in   map[string]interface{}
+		err  string
+	}{
+		{
+			name: "valid",
+			in:   map[string]interface{}{"backoff": "30s", "max_backoff": "10m"},
+		},
+		{
+			name: "max backoff of 0",
+			in:   map[string]interface{}{"max_backoff": 0},
+			err:  "retry max_backoff must be bigger than 0, got: 0",
+		},
+		{
+			name: "backoff of 0",
+			in:   map[string]interface{}{"backoff": "0s"},
+			err:  "retry backoff must be bigger than 0, got: 0s",
+		},
+		{
+			name: "max backoff smaller than backoff",
+			in:   map[string]interface{}{"backoff": "2h"},
+			err:  "retry max_backoff 1h0m0s is smaller than backoff 2h0m0s",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := buildRetry(tc.in, DefaultRetryPolicy())
+			got := ""
+			if err != nil {
+				got = err.Error()
+			}
+			if got != tc.err {
+				t.Errorf("expected error \"%s\", got: \"%s\"", tc.err, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package videoconv
+
+import (
+	"errors"
+	"fmt"
+	"github.com/AndresBott/videoconv/app/videoconv/config"
+	"github.com/AndresBott/videoconv/internal/fsutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// publishStep is the decision on how a file is moved into the output directory
+type publishStep struct {
+	src string
+	dst string
+	// the new file is deleted instead of moved, the existing one is kept
+	discard bool
+	// src is hardlinked to dst instead of moved
+	link bool
+}
+
+// errFileExists is returned by resolveConflict for an existing file with the fail policy
+var errFileExists = errors.New("file already exists")
+
+// resolveConflict decides where src is published according to the conflict policy
+// in case dst already exists, the returned message describes the decision
+func resolveConflict(policy, src, dst string) (publishStep, string, error) {
+	step := publishStep{src: src, dst: dst}
+	_, err := os.Stat(dst)
+	if os.IsNotExist(err) {
+		return step, "", nil
+	}
+	if err != nil {
+		return step, "", err
+	}
+
+	switch policy {
+	case config.ConflictSkip:
+		step.discard = true
+		return step, "file exists, keeping the existing one", nil
+
+	case config.ConflictFail:
+		return step, "", fmt.Errorf("%w: %s", errFileExists, dst)
+
+	case config.ConflictRename:
+		step.dst = freeName(dst)
+		return step, fmt.Sprintf("file exists, publishing as \"%s\"", filepath.Base(step.dst)), nil
+
+	case config.ConflictKeepLarger, config.ConflictKeepSmaller:
+		// directory outputs are compared by the size of all their files
+		newSize, err := fsutil.Size(src)
+		if err != nil {
+			return step, "", err
+		}
+		oldSize, err := fsutil.Size(dst)
+		if err != nil {
+			return step, "", err
+		}
+		newer := newSize > oldSize
+		if policy == config.ConflictKeepSmaller {
+			newer = newSize < oldSize
+		}
+		if !newer {
+			step.discard = true
+			return step, fmt.Sprintf("file exists, keeping the existing one (%d bytes, new %d bytes)", oldSize, newSize), nil
+		}
+		return step, fmt.Sprintf("file exists, replacing it (%d bytes, new %d bytes)", oldSize, newSize), nil
+
+	default:
+		return step, "file exists, overwriting it", nil
+	}
+}
+
+// conflictErr classifies an error of resolveConflict, an existing file does not go away by retrying
+func conflictErr(profile string, err error) error {
+	if errors.Is(err, errFileExists) {
+		return profileErr(config.ErrClassConflict, profile, err)
+	}
+	return profileErr(config.ErrClassIO, profile, err)
+}
+
+// freeName adds a counter to the name of the file until no file with that name exists, e.g. movie.h265.1.mkv
+func freeName(file string) string {
+	ext := filepath.Ext(file)
+	base := strings.TrimSuffix(file, ext)
+	for i := 1; ; i++ {
+		candidate := base + "." + strconv.Itoa(i) + ext
+		if _, err := os.Stat(candidate); os.IsNotExist(err) {
+			return candidate
+		}
+	}
+}
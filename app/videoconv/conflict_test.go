@@ -0,0 +1,118 @@
+package videoconv
+
+import (
+	"github.com/AndresBott/videoconv/app/videoconv/config"
+	"github.com/google/go-cmp/cmp"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOnConflict(t *testing.T) {
+	log.SetOutput(io.Discard) // discard the log entries
+
+	// new files are the transcoded "done" and the source video, the existing ones "existing rendition" and "old"
+	tcs := []struct {
+		policy string
+		expect map[string]string
+	}{
+		{
+			policy: config.ConflictOverwrite,
+			expect: map[string]string{
+				"out/nested/video.test.mp4": "done\n",
+				"out/nested/video.mp4":      "<source>",
+			},
+		},
+		{
+			policy: config.ConflictSkip,
+			expect: map[string]string{
+				"in/nested/video.mp4":       "<source>",
+				"out/nested/video.test.mp4": "existing rendition",
+				"out/nested/video.mp4":      "old",
+			},
+		},
+		{
+			policy: config.ConflictFail,
+			expect: map[string]string{
+				"out/nested/video.test.mp4":                  "existing rendition",
+				"out/nested/video.mp4":                       "old",
+				"fail/nested/video.mp4":                      "<source>",
+				"fail/nested/video.mp4.videoconv-error.json": "<report>",
+			},
+		},
+		{
+			policy: config.ConflictRename,
+			expect: map[string]string{
+				"out/nested/video.test.mp4":   "existing rendition",
+				"out/nested/video.test.1.mp4": "done\n",
+				"out/nested/video.mp4":        "old",
+				"out/nested/video.1.mp4":      "<source>",
+			},
+		},
+		{
+			policy: config.ConflictKeepLarger,
+			expect: map[string]string{
+				"out/nested/video.test.mp4": "existing rendition",
+				"out/nested/video.mp4":      "<source>",
+			},
+		},
+		{
+			policy: config.ConflictKeepSmaller,
+			expect: map[string]string{
+				"in/nested/video.mp4":       "<source>",
+				"out/nested/video.test.mp4": "done\n",
+				"out/nested/video.mp4":      "old",
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.policy, func(t *testing.T) {
+			vc, tmpPath := newVideConv(t)
+			vc.Cfg.LogLevel = "info"
+			err := os.MkdirAll(filepath.Join(tmpPath, "out/nested"), 0755)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = os.WriteFile(filepath.Join(tmpPath, "out/nested/video.test.mp4"), []byte("existing rendition"), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = os.WriteFile(filepath.Join(tmpPath, "out/nested/video.mp4"), []byte("old"), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, got := runJob(t, vc, tmpPath, "echo done > \"$last\"", func(location *config.Location) {
+				location.OnConflict = tc.policy
+			}, "in/nested", "out/nested", "fail/nested")
+			if diff := cmp.Diff(got, tc.expect); diff != "" {
+				t.Errorf("unexpected value (-got +want)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestConflictErr(t *testing.T) {
+	dst := filepath.Join(t.TempDir(), "video.mp4")
+	err := os.WriteFile(dst, []byte("old"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = resolveConflict(config.ConflictFail, "new.mp4", dst)
+	if err == nil {
+		t.Fatal("expected an error for an existing file")
+	}
+	class, profile := classify(conflictErr("test", err))
+	if class != config.ErrClassConflict || profile != "test" {
+		t.Errorf("unexpected class \"%s\" and profile \"%s\"", class, profile)
+	}
+	// the conflict class is not retried by default
+	for _, c := range config.DefaultRetryPolicy().RetryOn {
+		if c == config.ErrClassConflict {
+			t.Errorf("conflicts should not be retried by default")
+		}
+	}
+}
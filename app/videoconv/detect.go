@@ -0,0 +1,154 @@
+package videoconv
+
+import (
+	"github.com/AndresBott/videoconv/internal/ffprobe"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// results of sniffing the first bytes of a file
+const (
+	sniffUnknown = iota
+	sniffVideo
+	sniffNoVideo
+)
+
+// mp4 brands of containers that never hold video
+var noVideoBrands = []string{"M4A ", "M4B ", "M4P ", "F4A ", "F4B ", "heic", "heix", "mif1", "msf1", "avif"}
+
+// sniff guesses from the magic bytes if a file is a video container, formats that
+// can hold either audio or video (e.g. mkv, ogg) return sniffUnknown
+func sniff(head []byte) int {
+	has := func(offset int, magic string) bool {
+		return len(head) >= offset+len(magic) && string(head[offset:offset+len(magic)]) == magic
+	}
+
+	switch {
+	case has(4, "ftyp"):
+		if len(head) < 12 {
+			return sniffUnknown
+		}
+		brand := string(head[8:12])
+		for _, b := range noVideoBrands {
+			if brand == b {
+				return sniffNoVideo
+			}
+		}
+		return sniffVideo
+	case has(0, "RIFF") && has(8, "AVI "):
+		return sniffVideo
+	case has(0, "FLV"),
+		has(0, "\x00\x00\x01\xba"),                 // mpeg program stream
+		has(0, "\x30\x26\xb2\x75\x8e\x66\xcf\x11"): // asf / wmv
+		return sniffVideo
+	case len(head) > 188 && head[0] == 0x47 && head[188] == 0x47, // mpeg transport stream
+		len(head) > 196 && head[4] == 0x47 && head[196] == 0x47: // with timecodes, e.g. m2ts
+		return sniffVideo
+	case has(0, "\xff\xd8\xff"), // jpeg
+		has(0, "\x89PNG"),
+		has(0, "GIF8"),
+		has(0, "%PDF"),
+		has(0, "PK\x03\x04"),
+		has(0, "ID3"),
+		has(0, "fLaC"),
+		has(0, "RIFF") && has(8, "WAVE"),
+		has(0, "RIFF") && has(8, "WEBP"):
+		return sniffNoVideo
+	case len(head) >= 2 && head[0] == 0xff && head[1]&0xe0 == 0xe0 && head[1] != 0xff: // mpeg audio or aac frame
+		return sniffNoVideo
+	}
+	return sniffUnknown
+}
+
+// detectResult is the cached detection of a file
+type detectResult struct {
+	size    int64
+	modTime time.Time
+	video   bool
+	pass    uint64
+}
+
+// mediaDetector decides by content if a file is a video, results are cached
+// until the file changes so that the daemon does not probe the same files every pass
+type mediaDetector struct {
+	mu    sync.Mutex
+	files map[string]*detectResult
+	pass  uint64
+	probe func(file string) (ffprobe.ProbeData, error)
+}
+
+func newMediaDetector(probe func(file string) (ffprobe.ProbeData, error)) *mediaDetector {
+	return &mediaDetector{
+		files: map[string]*detectResult{},
+		probe: probe,
+	}
+}
+
+// newPass is called before searching the input directories, files not seen
+// during the previous pass are forgotten
+func (md *mediaDetector) newPass() {
+	md.mu.Lock()
+	defer md.mu.Unlock()
+	for k, f := range md.files {
+		if f.pass < md.pass {
+			delete(md.files, k)
+		}
+	}
+	md.pass++
+}
+
+// isVideo checks if the file contains a video stream, the magic bytes are checked
+// first and only unknown formats are probed
+func (md *mediaDetector) isVideo(file string, fInfo os.FileInfo) bool {
+	md.mu.Lock()
+	if r, ok := md.files[file]; ok && r.size == fInfo.Size() && r.modTime.Equal(fInfo.ModTime()) {
+		r.pass = md.pass
+		md.mu.Unlock()
+		return r.video
+	}
+	md.mu.Unlock()
+
+	video := md.detect(file)
+
+	md.mu.Lock()
+	md.files[file] = &detectResult{
+		size:    fInfo.Size(),
+		modTime: fInfo.ModTime(),
+		video:   video,
+		pass:    md.pass,
+	}
+	md.mu.Unlock()
+	return video
+}
+
+func (md *mediaDetector) detect(file string) bool {
+	f, err := os.Open(file)
+	if err != nil {
+		log.Warnf("unable to detect the type of \"%s\": %v", file, err)
+		return false
+	}
+	head := make([]byte, 512)
+	n, err := io.ReadFull(f, head)
+	f.Close()
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		log.Warnf("unable to detect the type of \"%s\": %v", file, err)
+		return false
+	}
+
+	switch sniff(head[:n]) {
+	case sniffVideo:
+		return true
+	case sniffNoVideo:
+		return false
+	}
+
+	data, err := md.probe(file)
+	if err != nil {
+		log.Debugf("ignoring \"%s\", unable to probe: %v", file, err)
+		return false
+	}
+	return data.HasVideo()
+}
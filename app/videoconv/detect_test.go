@@ -0,0 +1,125 @@
+package videoconv
+
+import (
+	"fmt"
+	"github.com/AndresBott/videoconv/internal/ffprobe"
+	"github.com/google/go-cmp/cmp"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSniff(t *testing.T) {
+	ts := make([]byte, 376)
+	ts[0], ts[188] = 0x47, 0x47
+
+	tcs := []struct {
+		name   string
+		in     string
+		expect int
+	}{
+		{name: "mp4", in: "\x00\x00\x00\x20ftypisom\x00\x00\x02\x00", expect: sniffVideo},
+		{name: "mov", in: "\x00\x00\x00\x14ftypqt  ", expect: sniffVideo},
+		{name: "m4a", in: "\x00\x00\x00\x20ftypM4A \x00\x00\x00\x00", expect: sniffNoVideo},
+		{name: "avi", in: "RIFF\x00\x00\x00\x00AVI LIST", expect: sniffVideo},
+		{name: "wav", in: "RIFF\x00\x00\x00\x00WAVEfmt ", expect: sniffNoVideo},
+		{name: "mpeg-ts", in: string(ts), expect: sniffVideo},
+		{name: "jpeg", in: "\xff\xd8\xff\xe0\x00\x10JFIF", expect: sniffNoVideo},
+		{name: "mp3", in: "ID3\x04\x00\x00", expect: sniffNoVideo},
+		{name: "mp3 frame", in: "\xff\xfb\x90\x64", expect: sniffNoVideo},
+		{name: "matroska is probed", in: "\x1a\x45\xdf\xa3\x01\x00", expect: sniffUnknown},
+		{name: "text", in: "just some notes", expect: sniffUnknown},
+		{name: "empty", in: "", expect: sniffUnknown},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := sniff([]byte(tc.in)); got != tc.expect {
+				t.Errorf("expected %d, got %d", tc.expect, got)
+			}
+		})
+	}
+}
+
+func TestMediaDetector(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"movie.mp4":   "\x00\x00\x00\x20ftypisom\x00\x00\x02\x00",
+		"cover.jpg":   "\xff\xd8\xff\xe0\x00\x10JFIF",
+		"movie":       "\x1a\x45\xdf\xa3 video",
+		"song.mka":    "\x1a\x45\xdf\xa3 audio",
+		"album.ogg":   "OggS cover",
+		"broken.file": "garbage",
+	}
+	for f, content := range files {
+		err := os.WriteFile(filepath.Join(dir, f), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var probed []string
+	md := newMediaDetector(func(file string) (ffprobe.ProbeData, error) {
+		probed = append(probed, filepath.Base(file))
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return ffprobe.ProbeData{}, err
+		}
+		switch {
+		case strings.HasSuffix(string(content), "video"):
+			return ffprobe.ProbeData{Streams: []ffprobe.Stream{{CodecType: "audio"}, {CodecType: "video"}}}, nil
+		case strings.HasSuffix(string(content), "audio"):
+			return ffprobe.ProbeData{Streams: []ffprobe.Stream{{CodecType: "audio"}}}, nil
+		case strings.HasSuffix(string(content), "cover"):
+			return ffprobe.ProbeData{Streams: []ffprobe.Stream{
+				{CodecType: "audio"},
+				{CodecType: "video", Disposition: ffprobe.StreamDisposition{AttachedPic: 1}},
+			}}, nil
+		}
+		return ffprobe.ProbeData{}, fmt.Errorf("invalid data found when processing input")
+	})
+
+	detect := func() []string {
+		var videos []string
+		for _, f := range []string{"album.ogg", "broken.file", "cover.jpg", "movie", "movie.mp4", "song.mka"} {
+			fInfo, err := os.Stat(filepath.Join(dir, f))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if md.isVideo(filepath.Join(dir, f), fInfo) {
+				videos = append(videos, f)
+			}
+		}
+		return videos
+	}
+
+	md.newPass()
+	if diff := cmp.Diff(detect(), []string{"movie", "movie.mp4"}); diff != "" {
+		t.Errorf("unexpected value (-got +want)\n%s", diff)
+	}
+	if diff := cmp.Diff(probed, []string{"album.ogg", "broken.file", "movie", "song.mka"}); diff != "" {
+		t.Errorf("unexpected value (-got +want)\n%s", diff)
+	}
+
+	// unchanged files are not probed again
+	probed = nil
+	md.newPass()
+	detect()
+	if len(probed) != 0 {
+		t.Errorf("expected cached results, probed: %v", probed)
+	}
+
+	// changed files are probed again
+	later := time.Now().Add(time.Minute)
+	err := os.Chtimes(filepath.Join(dir, "broken.file"), later, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	md.newPass()
+	detect()
+	if diff := cmp.Diff(probed, []string{"broken.file"}); diff != "" {
+		t.Errorf("unexpected value (-got +want)\n%s", diff)
+	}
+}
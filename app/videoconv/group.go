@@ -0,0 +1,55 @@
+package videoconv
+
+import (
+	"github.com/AndresBott/videoconv/internal/ffmpegtranscode"
+	"strings"
+	"time"
+)
+
+// renditionGroup are renditions transcoded by the same ffmpeg run
+type renditionGroup struct {
+	renditions []RenditionPlan
+	cmd        ffmpegtranscode.CmdArgs
+	duration   time.Duration // duration of the video, used for the progress
+}
+
+// label returns the profiles of the group for the logs
+func (g renditionGroup) label() string {
+	var profiles []string
+	for _, r := range g.renditions {
+		profiles = append(profiles, r.Profile)
+	}
+	return "profile \"" + strings.Join(profiles, "\", \"") + "\""
+}
+
+// groupRenditions returns the ffmpeg runs needed to transcode the renditions. With single_decode the
+// renditions with the same init args share one run with several outputs, the others run on their own,
+// as do the ones whose args add inputs or set options of the whole run, see ffmpegtranscode.Merge.
+func groupRenditions(j *job, renditions []RenditionPlan) []renditionGroup {
+	var groups []renditionGroup
+	for _, r := range renditions {
+		merged := false
+		// the passes of a multi-pass rendition run one after the other on their own
+		if j.location.SingleDecode && r.Passes == 0 {
+			for i := range groups {
+				if groups[i].renditions[0].Passes > 0 {
+					continue
+				}
+				cmd, err := ffmpegtranscode.Merge(groups[i].cmd, r.Cmd)
+				if err != nil {
+					j.log.Infof("profile \"%s\" can not share the decode with profile \"%s\": %v",
+						r.Profile, groups[i].renditions[0].Profile, err)
+					continue
+				}
+				groups[i].cmd = cmd
+				groups[i].renditions = append(groups[i].renditions, r)
+				merged = true
+				break
+			}
+		}
+		if !merged {
+			groups = append(groups, renditionGroup{renditions: []RenditionPlan{r}, cmd: r.Cmd})
+		}
+	}
+	return groups
+}
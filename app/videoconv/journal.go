@@ -0,0 +1,194 @@
+package videoconv
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/AndresBott/videoconv/internal/fsutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// journalDir is the directory inside the tmp dir of a location where the job records are stored
+const journalDir = ".journal"
+
+const (
+	statusPending = "pending"
+	statusRunning = "running"
+	statusDone    = "done"
+	statusFailed  = "failed"
+	// the rendition was moved to the output dir while other profiles failed
+	statusPublished = "published"
+)
+
+// jobRecord is the persisted state of the processing of one video
+type jobRecord struct {
+	// path of the video relative to the input directory
+	Video string `json:"video"`
+	// size and mtime identify the source, a record of a different file with the same name is discarded
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+	Status  string    `json:"status"`
+	Started time.Time `json:"started"`
+	Updated time.Time `json:"updated"`
+	// amount of times the video was processed, and when a failed video is tried again
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt,omitempty"`
+	LastError   string    `json:"last_error,omitempty"`
+	// profiles that still need to run, a retry only transcodes these
+	Remaining []string                  `json:"remaining,omitempty"`
+	Profiles  map[string]*profileRecord `json:"profiles"`
+}
+
+// profileRecord is the persisted state of a single rendition
+type profileRecord struct {
+	Status   string    `json:"status"`
+	Attempts int       `json:"attempts"`
+	Started  time.Time `json:"started,omitempty"`
+	Finished time.Time `json:"finished,omitempty"`
+	TmpFile  string    `json:"tmp_file"`
+	OutFile  string    `json:"out_file"`
+	// size of the tmp output once done, used to verify the file before resuming
+	OutSize int64  `json:"out_size,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// journal stores one json file per video in the tmp dir of a location
+type journal struct {
+	dir string
+}
+
+func newJournal(tmpDir string) journal {
+	return journal{
+		dir: filepath.Join(tmpDir, journalDir),
+	}
+}
+
+func (jr journal) file(relVideo string) string {
+	sum := sha1.Sum([]byte(relVideo))
+	return filepath.Join(jr.dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// newRecord creates an empty record for the video
+func newRecord(relVideo string, source os.FileInfo) *jobRecord {
+	return &jobRecord{
+		Video:    relVideo,
+		Size:     source.Size(),
+		ModTime:  source.ModTime(),
+		Status:   statusPending,
+		Profiles: map[string]*profileRecord{},
+	}
+}
+
+// load returns the record of the video, a new record is returned if none exists
+// or if the existing one belongs to a different file
+func (jr journal) load(relVideo string, source os.FileInfo) (*jobRecord, error) {
+	fresh := newRecord(relVideo, source)
+
+	b, err := os.ReadFile(jr.file(relVideo))
+	if os.IsNotExist(err) {
+		return fresh, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("unable to read journal: %v", err)
+	}
+
+	rec := jobRecord{}
+	err = json.Unmarshal(b, &rec)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse journal %s: %v", jr.file(relVideo), err)
+	}
+	if rec.Video != relVideo || rec.Size != source.Size() || !rec.ModTime.Equal(source.ModTime()) {
+		return fresh, nil
+	}
+	if rec.Profiles == nil {
+		rec.Profiles = map[string]*profileRecord{}
+	}
+	return &rec, nil
+}
+
+// skipReason returns why the video of the record is not processed in this pass, or an empty string
+func (rec *jobRecord) skipReason() string {
+	reason := ""
+	wait := time.Until(rec.NextAttempt)
+	switch {
+	case wait > 0:
+		reason = fmt.Sprintf("waiting %s before retrying", wait.Round(time.Second))
+	case rec.Status == statusDone:
+		return "already processed"
+	case rec.Status == statusFailed:
+		reason = "failed in a previous run and could not be moved to the fail dir"
+	default:
+		return ""
+	}
+	if rec.LastError != "" {
+		reason += ", last error: " + rec.LastError
+	}
+	return reason
+}
+
+// save writes the record to a temporary file and renames it, so that a crash never leaves a broken record
+func (jr journal) save(rec *jobRecord) error {
+	err := os.MkdirAll(jr.dir, 0755)
+	if err != nil {
+		return fmt.Errorf("unable to create journal dir: %v", err)
+	}
+	rec.Updated = time.Now()
+
+	b, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	target := jr.file(rec.Video)
+	tmp := target + ".tmp"
+	err = os.WriteFile(tmp, b, 0644)
+	if err != nil {
+		return fmt.Errorf("unable to write journal: %v", err)
+	}
+	return os.Rename(tmp, target)
+}
+
+// remove deletes the record of the video
+func (jr journal) remove(relVideo string) error {
+	err := os.Remove(jr.file(relVideo))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// profile returns the record of a profile, creating it if needed
+func (rec *jobRecord) profile(name string) *profileRecord {
+	pr, ok := rec.Profiles[name]
+	if !ok {
+		pr = &profileRecord{
+			Status: statusPending,
+		}
+		rec.Profiles[name] = pr
+	}
+	return pr
+}
+
+// isDone checks if the rendition was already completed in a previous run and its tmp output is still intact
+func (pr *profileRecord) isDone(tmpFile string) bool {
+	if pr.Status != statusDone || pr.TmpFile != tmpFile {
+		return false
+	}
+	size, err := fsutil.Size(tmpFile)
+	if err != nil {
+		return false
+	}
+	return size == pr.OutSize
+}
+
+// isPublished checks if the rendition was already moved to the output dir by a previous partial run
+func (pr *profileRecord) isPublished() bool {
+	if pr.Status != statusPublished {
+		return false
+	}
+	_, err := os.Stat(pr.OutFile)
+	return err == nil
+}
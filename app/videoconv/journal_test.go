@@ -0,0 +1,249 @@
+package videoconv
+
+import (
+	"context"
+	"github.com/AndresBott/videoconv/app/videoconv/config"
+	"github.com/google/go-cmp/cmp"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestProcessVideoResume(t *testing.T) {
+	log.SetOutput(io.Discard) // discard the log entries
+	vc, tmpPath := newVideConv(t)
+
+	// fake ffmpeg that records the output files it was called with
+	calls := filepath.Join(t.TempDir(), "calls")
+	fakeFfmpeg(t, vc, "echo \"$last\" >> "+calls+"\necho done > \"$last\"")
+
+	location := vc.Cfg.Locations[0]
+	location.Profiles = []config.Profile{
+		{Name: "first", Template: "empty"},
+		{Name: "second", Template: "empty"},
+	}
+
+	// simulate a crash after the first profile was done
+	firstTmp := filepath.Join(tmpPath, "tmp/nested/video.first.mp4")
+	err := os.MkdirAll(filepath.Dir(firstTmp), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(firstTmp, []byte("done\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source, err := os.Stat(filepath.Join(tmpPath, "in/nested/video.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jr := newJournal(filepath.Join(tmpPath, "tmp"))
+	rec, err := jr.load("nested/video.mp4", source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec.profile("first").Status = statusDone
+	rec.profile("first").TmpFile = firstTmp
+	rec.profile("first").OutSize = 5
+	rec.profile("second").Status = statusRunning
+	err = jr.save(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vc.processVideo(context.Background(), newJob(location, tmpPath, "nested/video.mp4"))
+
+	// only the unfinished profile is transcoded again
+	b, err := os.ReadFile(calls)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Split(strings.TrimSpace(string(b)), "\n")
+	want := []string{filepath.Join(tmpPath, "tmp/nested/video.second.mp4")}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("unexpected value (-got +want)\n%s", diff)
+	}
+
+	for _, f := range []string{"out/nested/video.first.mp4", "out/nested/video.second.mp4", "out/nested/video.mp4"} {
+		if _, err := os.Stat(filepath.Join(tmpPath, f)); err != nil {
+			t.Errorf("expected file %s: %v", f, err)
+		}
+	}
+
+	// the record is deleted once the video is done
+	if _, err := os.Stat(jr.file("nested/video.mp4")); !os.IsNotExist(err) {
+		t.Errorf("expected journal record to be deleted")
+	}
+}
+
+func TestProcessVideoPartialFailure(t *testing.T) {
+	log.SetOutput(io.Discard) // discard the log entries
+
+	tcs := []struct {
+		name      string
+		policy    string
+		remaining []string
+		published []string
+		// profiles transcoded by the retry
+		retried []string
+	}{
+		{
+			name:      "all or nothing",
+			policy:    config.PartialAllOrNothing,
+			remaining: []string{"bad", "last"},
+			retried:   []string{"bad", "last"},
+		},
+		{
+			name:      "continue others",
+			policy:    config.PartialContinueOthers,
+			remaining: []string{"bad"},
+			retried:   []string{"bad"},
+		},
+		{
+			name:      "publish successful",
+			policy:    config.PartialPublishSuccessful,
+			remaining: []string{"bad"},
+			published: []string{"out/nested/video.first.mp4", "out/nested/video.last.mp4"},
+			retried:   []string{"bad"},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			vc, tmpPath := newVideConv(t)
+			vc.Cfg.LogLevel = "info"
+			edit := func(location *config.Location) {
+				location.PartialFailure = tc.policy
+				location.Retry = config.RetryPolicy{
+					MaxAttempts: 2,
+					Backoff:     time.Hour,
+					RetryOn:     []string{config.ErrClassFfmpeg},
+				}
+				location.Profiles = []config.Profile{
+					{Name: "first", Template: "empty"},
+					{Name: "bad", Template: "empty"},
+					{Name: "last", Template: "empty"},
+				}
+			}
+			_, files := runJob(t, vc, tmpPath, "case \"$last\" in *.bad.*) exit 1;; esac\necho done > \"$last\"", edit, "out")
+
+			source, err := os.Stat(filepath.Join(tmpPath, "in/nested/video.mp4"))
+			if err != nil {
+				t.Fatalf("expected the video to stay in the input dir: %v", err)
+			}
+			jr := newJournal(filepath.Join(tmpPath, "tmp"))
+			rec, err := jr.load("nested/video.mp4", source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(rec.Remaining, tc.remaining); diff != "" {
+				t.Errorf("unexpected value (-got +want)\n%s", diff)
+			}
+			if diff := cmp.Diff(fileNames(files), tc.published); diff != "" {
+				t.Errorf("unexpected value (-got +want)\n%s", diff)
+			}
+
+			// the retry only transcodes the profiles that did not succeed
+			calls := filepath.Join(t.TempDir(), "calls")
+			rec.NextAttempt = time.Now().Add(-time.Second)
+			err = jr.save(rec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, files = runJob(t, vc, tmpPath, "basename \"$last\" >> "+calls+"\necho done > \"$last\"", edit, "out")
+
+			b, err := os.ReadFile(calls)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var retried []string
+			for _, f := range strings.Fields(string(b)) {
+				retried = append(retried, strings.Split(f, ".")[1])
+			}
+			if diff := cmp.Diff(retried, tc.retried); diff != "" {
+				t.Errorf("unexpected value (-got +want)\n%s", diff)
+			}
+			want := []string{"out/nested/video.bad.mp4", "out/nested/video.first.mp4", "out/nested/video.last.mp4", "out/nested/video.mp4"}
+			if diff := cmp.Diff(fileNames(files), want); diff != "" {
+				t.Errorf("unexpected value (-got +want)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPlanJournal(t *testing.T) {
+	log.SetOutput(io.Discard) // discard the log entries
+
+	tcs := []struct {
+		name   string
+		edit   func(rec *jobRecord)
+		expect string
+	}{
+		{
+			name:   "pending",
+			edit:   func(rec *jobRecord) {},
+			expect: "",
+		},
+		{
+			name: "done",
+			edit: func(rec *jobRecord) {
+				rec.Status = statusDone
+			},
+			expect: "already processed",
+		},
+		{
+			name: "failed",
+			edit: func(rec *jobRecord) {
+				rec.Status = statusFailed
+				rec.LastError = "exit status 1"
+			},
+			expect: "failed in a previous run and could not be moved to the fail dir, last error: exit status 1",
+		},
+		{
+			name: "backoff",
+			edit: func(rec *jobRecord) {
+				rec.Status = statusPending
+				rec.NextAttempt = time.Now().Add(time.Hour)
+				rec.LastError = "exit status 1"
+			},
+			expect: "waiting 1h0m0s before retrying, last error: exit status 1",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			vc, tmpPath := newVideConv(t)
+			source, err := os.Stat(filepath.Join(tmpPath, "in/nested/video.mp4"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			jr := newJournal(filepath.Join(tmpPath, "tmp"))
+			rec, err := jr.load("nested/video.mp4", source)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc.edit(rec)
+			err = jr.save(rec)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, plan := range vc.Plan() {
+				if plan.Video != filepath.Join(tmpPath, "in/nested/video.mp4") {
+					continue
+				}
+				if diff := cmp.Diff(plan.SkipReason, tc.expect); diff != "" {
+					t.Errorf("unexpected value (-got +want)\n%s", diff)
+				}
+				// skipped videos are not probed and rendered
+				if tc.expect != "" && plan.Renditions != nil {
+					t.Errorf("expected no renditions for a skipped video, got: %v", plan.Renditions)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package videoconv
+
+import (
+	"github.com/AndresBott/videoconv/app/videoconv/config"
+	"github.com/AndresBott/videoconv/internal/fsutil"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// isUpload checks if the file is still being uploaded, e.g. movie.nfo.part
+func isUpload(file string, uploadSuffixes []string) bool {
+	for _, suffix := range uploadSuffixes {
+		if strings.HasSuffix(file, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// tidyInput handles the leftover files of the input dir of a location as per policy and deletes empty directories,
+// it runs after the jobs of a pass so that the files of processed videos are already gone
+func (vc *Converter) tidyInput(location config.Location) {
+	graced := location.Leftovers == config.LeftoverMoveToOut || location.Leftovers == config.LeftoverMoveToFail ||
+		location.Leftovers == config.LeftoverDelete
+	if !graced && !location.PruneEmptyDirs {
+		return
+	}
+	locationPath, err := vc.locationPath(location)
+	if err != nil {
+		return
+	}
+	inDir := filepath.Join(locationPath, location.InputDir)
+	if _, err := os.Stat(inDir); err != nil {
+		return
+	}
+	filter := newVideoFilter(location, vc.Cfg.VideoExtensions, vc.detector)
+
+	if graced {
+		found, err := scanInput(inDir, filter)
+		if err != nil {
+			log.Warnf("unable to search the leftover files of location \"%s\": %v", location.Path, err)
+		} else {
+			handleLeftovers(location, locationPath, found)
+		}
+	}
+	if location.PruneEmptyDirs {
+		// an upload may create its directories long before the first file is written
+		pruneEmptyDirs(inDir, "", filter, location.LeftoverGrace)
+	}
+}
+
+// handleLeftovers moves or deletes the leftover files that don't belong to a video of the input dir
+// and have not been modified during the grace time
+func handleLeftovers(location config.Location, locationPath string, found scanResult) {
+	// files named after a video are never leftovers, they go away with the video or stay with it
+	owned := map[string]bool{}
+	for _, files := range matchSidecars(found, appendPattern(location.Sidecars, siblingPattern), location.UploadSuffixes) {
+		for _, f := range files {
+			owned[f] = true
+		}
+	}
+
+	inDir := filepath.Join(locationPath, location.InputDir)
+	deadline := time.Now().Add(-location.LeftoverGrace)
+	for _, rel := range found.leftovers {
+		if owned[rel] || isUpload(rel, location.UploadSuffixes) {
+			continue
+		}
+		src := filepath.Join(inDir, rel)
+		fInfo, err := os.Stat(src)
+		if err != nil || fInfo.ModTime().After(deadline) {
+			continue
+		}
+
+		if location.Leftovers == config.LeftoverDelete {
+			log.Infof("deleting leftover file \"%s\"", rel)
+			err = os.Remove(src)
+			if err != nil {
+				log.Warnf("unable to delete leftover file: %v", err)
+			}
+			continue
+		}
+
+		dir := location.OutputDir
+		if location.Leftovers == config.LeftoverMoveToFail {
+			dir = location.FailDir
+		}
+		// nothing is overwritten for a file nobody claimed
+		step, _, err := resolveConflict(config.ConflictRename, src, filepath.Join(locationPath, dir, rel))
+		if err != nil {
+			log.Warnf("unable to move leftover file \"%s\": %v", rel, err)
+			continue
+		}
+		log.Infof("moving leftover file \"%s\" to \"%s\"", rel, step.dst)
+		err = publish(step)
+		if err != nil {
+			log.Warnf("unable to move leftover file: %v", err)
+		}
+	}
+}
+
+// pruneEmptyDirs deletes the empty directories below rootPath/rel and returns true if the directory itself was deleted.
+// Directories that were empty already are only deleted once they have not been modified for minAge, so that
+// a directory just created by an upload is not deleted under its feet.
+func pruneEmptyDirs(rootPath, rel string, filter videoFilter, minAge time.Duration) bool {
+	dir := filepath.Join(rootPath, rel)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	removed := 0
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		entryRel := filepath.Join(rel, entry.Name())
+		if filter.skipDir(entryRel) {
+			continue
+		}
+		if pruneEmptyDirs(rootPath, entryRel, filter, minAge) {
+			removed++
+		}
+	}
+
+	// the input dir itself is never deleted
+	if rel == "" || removed < len(entries) {
+		return false
+	}
+	if removed == 0 {
+		fInfo, err := os.Stat(dir)
+		if err != nil || time.Since(fInfo.ModTime()) < minAge {
+			return false
+		}
+	}
+	log.Infof("deleting empty directory \"%s\"", rel)
+	err = os.Remove(dir)
+	if err != nil {
+		log.Warnf("unable to delete empty directory: %v", err)
+		return false
+	}
+	return true
+}
+
+// cleanTmpCopies deletes the partial copies that a move across filesystems left in the destination directories
+// of the location when the process died, it runs on start before any video is processed. Output dirs of profiles
+// are only searched below their part without template actions, e.g. "out" for "out/{{ .Profile.Name }}".
+func (vc *Converter) cleanTmpCopies(location config.Location) {
+	locationPath, err := vc.locationPath(location)
+	if err != nil {
+		return
+	}
+	dirs := []string{location.OutputDir, location.FailDir}
+	switch location.SourceAction {
+	case config.SourceArchive, config.SourceHardlink:
+		dirs = append(dirs, location.ArchiveDir)
+	case config.SourceTrash:
+		dirs = append(dirs, location.TrashDir)
+	}
+	for _, profile := range location.Profiles {
+		dir := profile.OutputDir
+		if i := strings.Index(dir, "{{"); i >= 0 {
+			dir = filepath.Dir(dir[:i])
+		}
+		if dir != "" && dir != "." {
+			dirs = append(dirs, dir)
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, dir := range dirs {
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(locationPath, dir)
+		}
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+		removed, err := fsutil.CleanTmp(dir)
+		for _, f := range removed {
+			log.Infof("deleted the partial copy \"%s\" of an interrupted move", f)
+		}
+		if err != nil {
+			log.Warnf("unable to clean the partial copies in \"%s\": %v", dir, err)
+		}
+	}
+}
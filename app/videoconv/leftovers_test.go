@@ -0,0 +1,257 @@
+package videoconv
+
+import (
+	"github.com/AndresBott/videoconv/app/videoconv/config"
+	"github.com/google/go-cmp/cmp"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSidecarPatterns(t *testing.T) {
+	location := config.Location{Sidecars: []string{"{name}-*"}}
+	if diff := cmp.Diff(sidecarPatterns(location), []string{"{name}-*"}); diff != "" {
+		t.Errorf("unexpected value (-got +want)\n%s", diff)
+	}
+	location.Leftovers = config.LeftoverMoveWithVideo
+	if diff := cmp.Diff(sidecarPatterns(location), []string{"{name}-*", "{name}.*"}); diff != "" {
+		t.Errorf("unexpected value (-got +want)\n%s", diff)
+	}
+	location.Sidecars = []string{"{name}.*"}
+	if diff := cmp.Diff(sidecarPatterns(location), []string{"{name}.*"}); diff != "" {
+		t.Errorf("unexpected value (-got +want)\n%s", diff)
+	}
+}
+
+func TestProcessVideoSiblings(t *testing.T) {
+	log.SetOutput(io.Discard) // discard the log entries
+
+	tcs := []struct {
+		name   string
+		script string
+		expect []string
+	}{
+		{
+			name:   "success",
+			script: "echo done > \"$last\"",
+			expect: []string{"in/nested/notes.txt", "out/nested/video.en.srt", "out/nested/video.mp4", "out/nested/video.nfo", "out/nested/video.test.mp4"},
+		},
+		{
+			name:   "failure",
+			script: "exit 1",
+			expect: []string{"fail/nested/video.en.srt", "fail/nested/video.mp4", "fail/nested/video.mp4.videoconv-error.json", "fail/nested/video.nfo", "in/nested/notes.txt"},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			vc, tmpPath := newVideConv(t)
+			vc.Cfg.LogLevel = "info"
+			// notes.txt is not named after the video, even if it is the only video of the directory
+			for _, f := range []string{"video.nfo", "video.en.srt", "notes.txt"} {
+				err := os.WriteFile(filepath.Join(tmpPath, "in/nested", f), []byte("data"), 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			_, got := runJob(t, vc, tmpPath, tc.script, func(location *config.Location) {
+				location.Leftovers = config.LeftoverMoveWithVideo
+			}, "fail/nested", "in/nested", "out/nested")
+			files := fileNames(got)
+			if diff := cmp.Diff(files, tc.expect); diff != "" {
+				t.Errorf("unexpected value (-got +want)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestTidyInput(t *testing.T) {
+	log.SetOutput(io.Discard) // discard the log entries
+
+	tcs := []struct {
+		policy string
+		expect []string
+	}{
+		{
+			policy: config.LeftoverIgnore,
+			expect: []string{"in/extras/new.txt", "in/extras/old.txt", "in/nested/video.mp4", "in/nested/video.nfo", "in/video1.MKV"},
+		},
+		{
+			policy: config.LeftoverMoveToOut,
+			expect: []string{"in/extras/new.txt", "in/nested/video.mp4", "in/nested/video.nfo", "in/video1.MKV", "out/extras/old.txt"},
+		},
+		{
+			policy: config.LeftoverMoveToFail,
+			expect: []string{"fail/extras/old.txt", "in/extras/new.txt", "in/nested/video.mp4", "in/nested/video.nfo", "in/video1.MKV"},
+		},
+		{
+			policy: config.LeftoverDelete,
+			expect: []string{"in/extras/new.txt", "in/nested/video.mp4", "in/nested/video.nfo", "in/video1.MKV"},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.policy, func(t *testing.T) {
+			vc, tmpPath := newVideConv(t)
+			err := os.MkdirAll(filepath.Join(tmpPath, "in/extras"), 0755)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the nfo belongs to a video and is not a leftover, even if it is old
+			old := time.Now().Add(-2 * time.Hour)
+			for _, f := range []string{"nested/video.nfo", "extras/old.txt", "extras/new.txt"} {
+				file := filepath.Join(tmpPath, "in", f)
+				err = os.WriteFile(file, []byte("data"), 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if f != "extras/new.txt" {
+					err = os.Chtimes(file, old, old)
+					if err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+
+			location := vc.Cfg.Locations[0]
+			location.Leftovers = tc.policy
+			location.LeftoverGrace = time.Hour
+			vc.tidyInput(location)
+
+			var files []string
+			for _, dir := range []string{"fail", "in", "out"} {
+				err = filepath.Walk(filepath.Join(tmpPath, dir), func(fPath string, fInfo os.FileInfo, err error) error {
+					if err != nil || fInfo.IsDir() {
+						return err
+					}
+					rel, _ := filepath.Rel(tmpPath, fPath)
+					files = append(files, rel)
+					return nil
+				})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if diff := cmp.Diff(files, tc.expect); diff != "" {
+				t.Errorf("unexpected value (-got +want)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestPruneEmptyDirs(t *testing.T) {
+	log.SetOutput(io.Discard) // discard the log entries
+	dir := t.TempDir()
+	for _, d := range []string{"a/b/c", "d", "e/f", ".hidden", "fresh"} {
+		err := os.MkdirAll(filepath.Join(dir, d), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := os.WriteFile(filepath.Join(dir, "e/file.txt"), []byte("data"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	for _, d := range []string{"a/b/c", "d", "e/f", ".hidden"} {
+		err = os.Chtimes(filepath.Join(dir, d), old, old)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pruneEmptyDirs(dir, "", videoFilter{skipHidden: true}, time.Minute)
+
+	var dirs []string
+	err = filepath.Walk(dir, func(fPath string, fInfo os.FileInfo, err error) error {
+		if err != nil || !fInfo.IsDir() || fPath == dir {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, fPath)
+		dirs = append(dirs, rel)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(dirs, []string{".hidden", "e", "fresh"}); diff != "" {
+		t.Errorf("unexpected value (-got +want)\n%s", diff)
+	}
+}
+
+func TestTidyInputPrune(t *testing.T) {
+	log.SetOutput(io.Discard) // discard the log entries
+	vc, tmpPath := newVideConv(t)
+	for _, d := range []string{"in/old", "in/upload"} {
+		err := os.MkdirAll(filepath.Join(tmpPath, d), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-2 * time.Hour)
+	err := os.Chtimes(filepath.Join(tmpPath, "in/old"), old, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the settle time is not set, the fresh directory is still kept for the grace time
+	location := vc.Cfg.Locations[0]
+	location.PruneEmptyDirs = true
+	location.SettleTime = 0
+	location.LeftoverGrace = time.Hour
+	vc.tidyInput(location)
+
+	entries, err := os.ReadDir(filepath.Join(tmpPath, "in"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dirs []string
+	for _, entry := range entries {
+		dirs = append(dirs, entry.Name())
+	}
+	if diff := cmp.Diff(dirs, []string{"nested", "upload", "video1.MKV"}); diff != "" {
+		t.Errorf("unexpected value (-got +want)\n%s", diff)
+	}
+}
+
+func TestCleanTmpCopies(t *testing.T) {
+	log.SetOutput(io.Discard) // discard the log entries
+	vc, tmpPath := newVideConv(t)
+	files := []string{
+		"out/nested/.videoconv-video.mp4",
+		"out/nested/video.mp4",
+		"fail/.videoconv-broken.mkv",
+		"renders/small/.videoconv-video.small.mp4",
+		"in/nested/.videoconv-upload.mkv",
+	}
+	for _, f := range files {
+		err := os.MkdirAll(filepath.Join(tmpPath, filepath.Dir(f)), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(filepath.Join(tmpPath, f), []byte("data"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	location := vc.Cfg.Locations[0]
+	location.Profiles = []config.Profile{{Name: "small", Template: "empty", OutputDir: "renders/{{ .Profile.Name }}"}}
+	vc.cleanTmpCopies(location)
+
+	var got []string
+	for _, f := range files {
+		if _, err := os.Stat(filepath.Join(tmpPath, f)); err == nil {
+			got = append(got, f)
+		}
+	}
+	// the input dir is not a destination, a file named like a copy there belongs to the user
+	want := []string{"out/nested/video.mp4", "in/nested/.videoconv-upload.mkv"}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("unexpected value (-got +want)\n%s", diff)
+	}
+}
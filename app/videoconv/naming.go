@@ -0,0 +1,149 @@
+package videoconv
+
+import (
+	"fmt"
+	"github.com/AndresBott/videoconv/app/videoconv/config"
+	"github.com/AndresBott/videoconv/internal/tmpl"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// fileData describes the source video to the templates
+type fileData struct {
+	Name     string // file name, e.g. movie.mkv
+	BaseName string // file name without extension, e.g. movie
+	Ext      string // extension without dot, e.g. mkv
+	Dir      string // directory relative to the input dir, "." for the input dir itself
+	Path     string // path relative to the input dir
+	Size     int64
+	ModTime  time.Time
+}
+
+func newFileData(source os.FileInfo, relativePath string) fileData {
+	ext := filepath.Ext(source.Name())
+	return fileData{
+		Name:     source.Name(),
+		BaseName: strings.TrimSuffix(source.Name(), ext),
+		Ext:      strings.TrimPrefix(ext, "."),
+		Dir:      filepath.Dir(relativePath),
+		Path:     relativePath,
+		Size:     source.Size(),
+		ModTime:  source.ModTime(),
+	}
+}
+
+// max length in bytes of a single element of an output name, most filesystems allow 255
+const maxNameLen = 240
+
+// outputName returns the name of a rendition relative to its output directory, the output_name of the
+// profile takes precedence over the one of the template; if none is set defaultName is used
+func outputName(profile config.Profile, data videoData, tmplData templateData, defaultName string) (string, error) {
+	name := tmplData.OutputName
+	if profile.OutputName != "" {
+		var err error
+		name, err = tmpl.Render(profile.OutputName, data)
+		if err != nil {
+			return "", fmt.Errorf("error rendering output_name: %v", err)
+		}
+	}
+	if name == "" {
+		return defaultName, nil
+	}
+
+	name, err := sanitizeName(name)
+	if err != nil {
+		return "", err
+	}
+	ext := strings.Trim(tmplData.Extension, ".")
+	if ext == "" {
+		ext = data.File.Ext
+	}
+	// directories have no extension
+	if tmplData.OutputMode != outputModeDirectory && ext != "" && !strings.HasSuffix(strings.ToLower(name), "."+strings.ToLower(ext)) {
+		name = name + "." + ext
+	}
+	return name, nil
+}
+
+// sanitizeName makes a rendered name safe to be used as path relative to the output dir: "/" creates
+// subdirectories, characters not allowed on common filesystems are replaced, and elements like ".."
+// are dropped so that the name can not escape the output dir
+func sanitizeName(name string) (string, error) {
+	var elements []string
+	for _, e := range strings.Split(name, "/") {
+		e = strings.Map(func(r rune) rune {
+			switch {
+			case r < 0x20 || r == 0x7f:
+				return -1
+			case strings.ContainsRune(`\:*?"<>|`, r):
+				return '_'
+			}
+			return r
+		}, e)
+		e = strings.Trim(e, " .")
+		for len(e) > maxNameLen {
+			_, size := utf8.DecodeLastRuneInString(e)
+			e = e[:len(e)-size]
+		}
+		if e != "" {
+			elements = append(elements, e)
+		}
+	}
+	if len(elements) == 0 {
+		return "", fmt.Errorf("output name \"%s\" is empty once sanitized", name)
+	}
+	return filepath.Join(elements...), nil
+}
+
+// outputDir returns the directory a rendition is published to: the rendered output_dir of the profile,
+// relative to the location without leaving it or absolute, or else the output dir of the location. The relative
+// directory of the video is mirrored in both cases. Directories inside the input dir are refused, the renditions
+// would be found as new videos.
+func outputDir(j *job, profile config.Profile, data videoData, relDir string) (string, error) {
+	if profile.OutputDir == "" {
+		return filepath.Join(j.out, relDir), nil
+	}
+	dir, err := tmpl.Render(profile.OutputDir, data)
+	if err != nil {
+		return "", fmt.Errorf("error rendering output_dir: %v", err)
+	}
+	if dir == "" {
+		return "", fmt.Errorf("output_dir \"%s\" is empty once rendered", profile.OutputDir)
+	}
+	dir = filepath.Clean(dir)
+	if !filepath.IsAbs(dir) {
+		if dir == ".." || strings.HasPrefix(dir, ".."+string(filepath.Separator)) {
+			return "", fmt.Errorf("output_dir \"%s\" leaves the location once rendered: %s", profile.OutputDir, dir)
+		}
+		dir = filepath.Join(j.path, dir)
+	}
+	if rel, err := filepath.Rel(j.in, dir); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("output_dir \"%s\" is inside the input dir once rendered: %s", profile.OutputDir, dir)
+	}
+	return filepath.Join(dir, relDir), nil
+}
+
+// directoryMainFile returns the name of the file ffmpeg writes inside the output directory of a template
+// in directory mode, e.g. index.m3u8, and an empty string for single file outputs
+func directoryMainFile(tmplData templateData) (string, error) {
+	switch tmplData.OutputMode {
+	case "", outputModeFile:
+		return "", nil
+	case outputModeDirectory:
+	default:
+		return "", fmt.Errorf("unknown output_mode \"%s\", allowed: %s, %s", tmplData.OutputMode, outputModeFile, outputModeDirectory)
+	}
+
+	name := tmplData.OutputFile
+	if name == "" {
+		ext := strings.Trim(tmplData.Extension, ".")
+		if ext == "" {
+			return "", fmt.Errorf("directory outputs need an output_file or an extension")
+		}
+		name = "index." + ext
+	}
+	return sanitizeName(name)
+}
@@ -0,0 +1,252 @@
+package videoconv
+
+import (
+	"github.com/AndresBott/videoconv/app/videoconv/config"
+	"github.com/google/go-cmp/cmp"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeName(t *testing.T) {
+	tcs := []struct {
+		in     string
+		expect string
+		err    bool
+	}{
+		{in: "Show - S01E02 [1080p HEVC]", expect: "Show - S01E02 [1080p HEVC]"},
+		{in: "2021/07/clip 10:30:00", expect: "2021/07/clip 10_30_00"},
+		{in: "../../etc/passwd", expect: "etc/passwd"},
+		{in: "/abs/./name. ", expect: "abs/name"},
+		{in: "tab\tand \"quotes\"?", expect: "taband _quotes__"},
+		{in: ".hidden", expect: "hidden"},
+		{in: strings.Repeat("é", 200), expect: strings.Repeat("é", 120)},
+		{in: "../..", err: true},
+		{in: " ", err: true},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.in, func(t *testing.T) {
+			got, err := sanitizeName(tc.in)
+			if tc.err {
+				if err == nil {
+					t.Errorf("expected an error, got: %s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.expect {
+				t.Errorf("expected \"%s\", got \"%s\"", tc.expect, got)
+			}
+		})
+	}
+}
+
+func TestOutputName(t *testing.T) {
+	data := videoData{
+		Profile: map[string]string{"quality": "HEVC"},
+		File:    fileData{Name: "show.s01e02.mp4", BaseName: "show.s01e02", Ext: "mp4"},
+	}
+	data.Video.Summary.Video.H = 1080
+
+	tcs := []struct {
+		name     string
+		profile  config.Profile
+		tmplData templateData
+		expect   string
+	}{
+		{
+			name:   "default",
+			expect: "show.s01e02.hd.mp4",
+		},
+		{
+			name: "template name",
+			// the template renders its output_name itself
+			tmplData: templateData{OutputName: "Show - S01E02", Extension: "mkv"},
+			expect:   "Show - S01E02.mkv",
+		},
+		{
+			name:     "profile name takes precedence",
+			profile:  config.Profile{OutputName: `{{ .File.BaseName | upper }} [{{ .Video.Summary.Video.H }}p {{ .Profile.quality }}]`},
+			tmplData: templateData{OutputName: "ignored", Extension: "mkv"},
+			expect:   "SHOW.S01E02 [1080p HEVC].mkv",
+		},
+		{
+			name:    "extension is not repeated",
+			profile: config.Profile{OutputName: "{{ .File.Name }}"},
+			expect:  "show.s01e02.mp4",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := outputName(tc.profile, data, tc.tmplData, "show.s01e02.hd.mp4")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.expect {
+				t.Errorf("expected \"%s\", got \"%s\"", tc.expect, got)
+			}
+		})
+	}
+}
+
+func TestPlanOutputName(t *testing.T) {
+	log.SetOutput(io.Discard) // discard the log entries
+	vc, tmpPath := newVideConv(t)
+
+	location := vc.Cfg.Locations[0]
+	location.Profiles = []config.Profile{
+		{Name: "a", Template: "mkv", OutputName: "{{ .File.Dir }}/../{{ .File.BaseName }} [{{ .Video.Summary.Video.H }}p]"},
+		{Name: "b", Template: "empty"},
+	}
+	plan, err := vc.planVideo(newJob(location, tmpPath, "nested/video.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range plan.Renditions {
+		got = append(got, r.OutFile, r.TmpFile)
+	}
+	want := []string{
+		filepath.Join(tmpPath, "out/nested/nested/video [180p].mkv"),
+		filepath.Join(tmpPath, "tmp/nested/video.a.mkv"),
+		filepath.Join(tmpPath, "out/nested/video.b.mp4"),
+		filepath.Join(tmpPath, "tmp/nested/video.b.mp4"),
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("unexpected value (-got +want)\n%s", diff)
+	}
+
+	// two profiles must not overwrite each other
+	location.Profiles[1].OutputName = "{{ .File.BaseName }} [180p]"
+	location.Profiles[0].OutputName = "{{ .File.BaseName }} [180p]"
+	location.Profiles[1].Template = "mkv"
+	_, err = vc.planVideo(newJob(location, tmpPath, "nested/video.mp4"))
+	if err == nil || !strings.Contains(err.Error(), "same output file") {
+		t.Errorf("expected an error for duplicated output files, got: %v", err)
+	}
+
+	// a rendition must not take the place of the source moved to the output dir
+	location.Profiles = []config.Profile{
+		{Name: "a", Template: "empty", OutputName: "{{ .File.BaseName }}"},
+	}
+	_, err = vc.planVideo(newJob(location, tmpPath, "nested/video.mp4"))
+	if err == nil || !strings.Contains(err.Error(), "the source video and profile \"a\" have the same output file") {
+		t.Errorf("expected an error for a rendition named like the source, got: %v", err)
+	}
+
+	// nor the place of a sidecar
+	location.Profiles[0].OutputName = "{{ .File.BaseName }}.en.srt"
+	location.Profiles[0].Template = "extension"
+	location.Profiles[0].Args = map[string]string{"Extension": "srt"}
+	j := newJob(location, tmpPath, "nested/video.mp4")
+	j.sidecars = []string{"nested/video.en.srt"}
+	_, err = vc.planVideo(j)
+	if err == nil || !strings.Contains(err.Error(), "the sidecar file \"video.en.srt\"") {
+		t.Errorf("expected an error for a rendition named like a sidecar, got: %v", err)
+	}
+}
+
+func TestOutputDir(t *testing.T) {
+	location := config.Location{Path: "./", InputDir: "in", OutputDir: "out"}
+	j := newJob(location, "/srv/location", "nested/video.mp4")
+
+	tcs := []struct {
+		name      string
+		outputDir string
+		want      string
+		err       string
+	}{
+		{name: "location output dir", want: "/srv/location/out/nested"},
+		{name: "relative", outputDir: "out/./mobile/", want: "/srv/location/out/mobile/nested"},
+		{name: "absolute", outputDir: "/srv/media/../media", want: "/srv/media/nested"},
+		{name: "relative inside the location", outputDir: "out/../published", want: "/srv/location/published/nested"},
+		{name: "relative leaving the location", outputDir: "out/../../media", err: "leaves the location"},
+		{name: "relative inside the input dir", outputDir: "in/mobile", err: "is inside the input dir"},
+		{name: "absolute input dir", outputDir: "/srv/location/in/", err: "is inside the input dir"},
+		{name: "input dir after cleaning", outputDir: "out/../in", err: "is inside the input dir"},
+		{name: "next to the input dir", outputDir: "/srv/location/inbox", want: "/srv/location/inbox/nested"},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := outputDir(j, config.Profile{Name: "test", OutputDir: tc.outputDir}, videoData{}, "nested")
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Errorf("expected error \"%s\", got: %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.want {
+				t.Errorf("expected \"%s\", got: \"%s\"", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestProcessVideoOutputDir(t *testing.T) {
+	log.SetOutput(io.Discard) // discard the log entries
+	vc, tmpPath := newVideConv(t)
+	target := t.TempDir()
+
+	got, files := runJob(t, vc, tmpPath, "echo done > \"$last\"", func(location *config.Location) {
+		location.Profiles = []config.Profile{
+			{Name: "mobile", Template: "empty", OutputDir: "out/{{ .Profile.Name }}"},
+			{Name: "archive", Template: "mkv", OutputDir: target + "/{{ .Video.Summary.Video.H }}p"},
+			{Name: "default", Template: "empty"},
+		}
+	}, "out")
+	if got.Outcome != OutcomeDone {
+		t.Fatalf("unexpected result: %+v", got)
+	}
+	want := []string{"out/mobile/nested/video.mobile.mp4", "out/nested/video.default.mp4", "out/nested/video.mp4"}
+	if diff := cmp.Diff(fileNames(files), want); diff != "" {
+		t.Errorf("unexpected value (-got +want)\n%s", diff)
+	}
+	if _, err := os.Stat(filepath.Join(target, "180p/nested/video.archive.mkv")); err != nil {
+		t.Errorf("expected output file: %v", err)
+	}
+}
+
+func TestDirectoryMainFile(t *testing.T) {
+	tcs := []struct {
+		name   string
+		in     templateData
+		expect string
+		err    bool
+	}{
+		{name: "single file", in: templateData{Extension: "mkv"}},
+		{name: "output file", in: templateData{OutputMode: outputModeDirectory, OutputFile: "master.m3u8"}, expect: "master.m3u8"},
+		{name: "from extension", in: templateData{OutputMode: outputModeDirectory, Extension: "mpd"}, expect: "index.mpd"},
+		{name: "escaping name", in: templateData{OutputMode: outputModeDirectory, OutputFile: "../index.m3u8"}, expect: "index.m3u8"},
+		{name: "missing name", in: templateData{OutputMode: outputModeDirectory}, err: true},
+		{name: "unknown mode", in: templateData{OutputMode: "tar"}, err: true},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := directoryMainFile(tc.in)
+			if tc.err {
+				if err == nil {
+					t.Errorf("expected an error, got: %s", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.expect {
+				t.Errorf("expected \"%s\", got \"%s\"", tc.expect, got)
+			}
+		})
+	}
+}
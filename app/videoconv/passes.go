@@ -0,0 +1,110 @@
+package videoconv
+
+import (
+	"context"
+	"fmt"
+	"github.com/AndresBott/videoconv/app/videoconv/config"
+	"github.com/AndresBott/videoconv/internal/ffmpegtranscode"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// passData is a single ffmpeg run of a multi-pass template
+type passData struct {
+	Args []string `json:"args"`
+	// the rendition if empty, analysis passes set "-" together with "-f null"
+	Output string `json:"output"`
+}
+
+// text/template prints missing map keys, e.g. a measurement that was not printed, as this
+const noValue = "<no value>"
+
+// passLogFile returns the prefix of the pass log files of a rendition, next to its tmp file
+func passLogFile(j *job, relDir string, file fileData, profile string) string {
+	return filepath.Join(j.tmp, relDir, file.BaseName+"."+profile+".passlog")
+}
+
+// passCmd returns the ffmpeg command of a pass, numbered from 1, of the rendered template
+func (vc *Converter) passCmd(j *job, r RenditionPlan, tmplData templateData, pass int) (ffmpegtranscode.CmdArgs, error) {
+	if pass > len(tmplData.Passes) {
+		return ffmpegtranscode.CmdArgs{}, fmt.Errorf("the template renders %d passes, pass %d is missing", len(tmplData.Passes), pass)
+	}
+	p := tmplData.Passes[pass-1]
+	output := r.output()
+	switch p.Output {
+	case "":
+	case "-":
+		output = p.Output
+	default:
+		// passes don't write anywhere else than the tmp dir
+		return ffmpegtranscode.CmdArgs{}, fmt.Errorf("the output of pass %d can only be \"-\" or empty, got \"%s\"", pass, p.Output)
+	}
+	return vc.ffmpeg.GetCmd(j.video, output, dropEmpty(tmplData.Init), dropEmpty(p.Args))
+}
+
+// runPasses runs the passes of a multi-pass rendition one after the other and returns the last command run.
+// Every pass after the first renders the template again with the measurements printed by the previous ones,
+// the pass log files are deleted at the end.
+func (vc *Converter) runPasses(ctx context.Context, j *job, r RenditionPlan, duration time.Duration) (ffmpegtranscode.CmdArgs, error) {
+	defer removePassLogs(j, r.vars.PassLogFile)
+
+	cmd := r.Cmd
+	measurements := map[string]string{}
+	for pass := 1; pass <= r.Passes; pass++ {
+		if pass > 1 {
+			data := r.vars
+			data.Pass = pass
+			data.Measurements = measurements
+			data.LocalData = map[string]interface{}{}
+			tmplData := templateData{}
+			err := r.template.ParseJson(data, &tmplData)
+			if err != nil {
+				return cmd, profileErr(config.ErrClassTemplate, r.Profile, fmt.Errorf("error parsing template for pass %d: %v", pass, err))
+			}
+			cmd, err = vc.passCmd(j, r, tmplData, pass)
+			if err != nil {
+				return cmd, profileErr(config.ErrClassTemplate, r.Profile, err)
+			}
+			for _, a := range cmd.Slice() {
+				if strings.Contains(a, noValue) {
+					return cmd, profileErr(config.ErrClassTemplate, r.Profile,
+						fmt.Errorf("pass %d uses a value that is missing, e.g. a measurement the previous passes did not print: %s", pass, a))
+				}
+			}
+		}
+		j.log.Debugf("ffmpeg cmd of pass %d/%d: %s", pass, r.Passes, cmd.String())
+
+		label := fmt.Sprintf("profile \"%s\" pass %d/%d", r.Profile, pass, r.Passes)
+		stderr, err := vc.ffmpeg.ExecProgress(ctx, cmd, duration, logProgress(j, label))
+		if err != nil {
+			return cmd, fmt.Errorf("pass %d: %w", pass, err)
+		}
+		m := ffmpegtranscode.Measurements(stderr)
+		for k, v := range m {
+			measurements[k] = v
+		}
+		if len(m) > 0 {
+			j.log.Debugf("measurements of pass %d: %v", pass, m)
+		}
+	}
+	return cmd, nil
+}
+
+// removePassLogs deletes the files written by ffmpeg with the prefix, e.g. prefix-0.log and prefix-0.log.mbtree
+func removePassLogs(j *job, prefix string) {
+	entries, err := os.ReadDir(filepath.Dir(prefix))
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), filepath.Base(prefix)) {
+			continue
+		}
+		err = os.RemoveAll(filepath.Join(filepath.Dir(prefix), entry.Name()))
+		if err != nil {
+			j.log.Warnf("unable to delete pass log file: %v", err)
+		}
+	}
+}
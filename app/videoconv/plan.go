@@ -0,0 +1,304 @@
+package videoconv
+
+import (
+	"fmt"
+	"github.com/AndresBott/videoconv/app/videoconv/config"
+	"github.com/AndresBott/videoconv/internal/expr"
+	"github.com/AndresBott/videoconv/internal/ffmpegtranscode"
+	"github.com/AndresBott/videoconv/internal/ffprobe"
+	"github.com/AndresBott/videoconv/internal/tmpl"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// VideoPlan describes all the actions taken to process a single video
+type VideoPlan struct {
+	Location   string          `json:"location"`
+	Video      string          `json:"video"`
+	Renditions []RenditionPlan `json:"renditions"`
+	// the video is not processed in this pass as per its journal record, e.g. it is already done
+	SkipReason string `json:"skip_reason,omitempty"`
+	// profiles not run because their when condition is false
+	Skipped []string `json:"skipped,omitempty"`
+	// what happens with the source video once all renditions are done, and where it is moved to
+	SourceAction string `json:"source_action"`
+	SourceOut    string `json:"source_out"`
+	// files moved along with the video, relative to the input dir
+	Sidecars []string `json:"sidecars,omitempty"`
+	Error    string   `json:"error,omitempty"`
+	// probe result, used for failure reports
+	probe *ffprobe.ProbeData
+}
+
+// RenditionPlan describes the ffmpeg execution of a single profile
+type RenditionPlan struct {
+	Profile  string                  `json:"profile"`
+	Template string                  `json:"template"`
+	Cmd      ffmpegtranscode.CmdArgs `json:"cmd"`
+	TmpFile  string                  `json:"tmp_file"`
+	OutFile  string                  `json:"out_file"`
+	// for directory outputs TmpFile and OutFile are directories, this is the file ffmpeg writes inside them
+	MainFile string `json:"main_file,omitempty"`
+	// number of ffmpeg runs of a multi-pass template, Cmd is the first one
+	Passes int `json:"passes,omitempty"`
+	// rendered template, used for failure reports
+	data templateData
+	// template and data to render the passes after the first one
+	template tmpl.Template
+	vars     videoData
+}
+
+// isDir checks if the rendition is a directory, e.g. a hls playlist and its segments
+func (r RenditionPlan) isDir() bool {
+	return r.MainFile != ""
+}
+
+// output returns the path of the file written by ffmpeg
+func (r RenditionPlan) output() string {
+	if r.isDir() {
+		return filepath.Join(r.TmpFile, r.MainFile)
+	}
+	return r.TmpFile
+}
+
+// Plan walks all locations, probes every video and renders all the profile templates
+// without running ffmpeg or changing anything on the filesystem
+func (vc *Converter) Plan() []VideoPlan {
+	var plans []VideoPlan
+	for i, location := range vc.Cfg.Locations {
+		jobs, err := vc.locationJobs(i)
+		if err != nil {
+			log.Errorf("skipping location \"%s\": %v", location.Path, err)
+			continue
+		}
+		for _, j := range jobs {
+			if reason := vc.journalSkip(j); reason != "" {
+				plans = append(plans, VideoPlan{Location: j.location.Path, Video: j.video, SkipReason: reason})
+				continue
+			}
+			vc.checkWhen(j)
+			// lease the resources so that the templates see the same values as in a real run
+			lease, _, err := vc.resources.TryAcquire(j.pools()...)
+			if err != nil {
+				j.log.Warnf("unable to lease resources: %v", err)
+			}
+			j.lease = lease
+
+			plan, err := vc.planVideo(j)
+			if err != nil {
+				plan.Error = err.Error()
+			}
+			plans = append(plans, plan)
+
+			j.lease.Release()
+			j.lease = nil
+		}
+	}
+	return plans
+}
+
+// journalSkip returns why the video of the job is not processed in this pass according to its journal record,
+// the journal is only read
+func (vc *Converter) journalSkip(j *job) string {
+	relativePath, err := j.relPath()
+	if err != nil {
+		return ""
+	}
+	source, err := os.Stat(j.video)
+	if err != nil {
+		return ""
+	}
+	rec, err := newJournal(j.tmp).load(relativePath, source)
+	if err != nil {
+		j.log.Warnf("ignoring journal record: %v", err)
+		return ""
+	}
+	return rec.skipReason()
+}
+
+// planVideo probes the video of the job and renders the templates of all profiles
+func (vc *Converter) planVideo(j *job) (VideoPlan, error) {
+	plan := VideoPlan{
+		Location: j.location.Path,
+		Video:    j.video,
+	}
+
+	relativePath, err := j.relPath()
+	if err != nil {
+		return plan, classErr(config.ErrClassIO, err)
+	}
+	relDir := filepath.Dir(relativePath)
+	plan.SourceAction = j.location.SourceAction
+	plan.SourceOut = sourceTarget(j, relativePath)
+	plan.Sidecars = j.sidecars
+	sidecars := newSidecarData(j)
+
+	// the video may already be probed to check the conditions of the profiles before leasing resources
+	if j.probe == nil {
+		probeData, err := vc.ffprobe.Probe(j.video)
+		if err != nil {
+			return plan, classErr(config.ErrClassProbe, fmt.Errorf("unable to run ffprobe on video: %v", err))
+		}
+		j.probe = &probeData
+	}
+	probeData := *j.probe
+	plan.probe = j.probe
+
+	source, err := os.Stat(j.video)
+	if err != nil {
+		return plan, classErr(config.ErrClassIO, err)
+	}
+	env := whenEnv(probeData, source, relativePath)
+	file := newFileData(source, relativePath)
+	// the source and the sidecars end up next to the renditions, no rendition may take their place
+	outFiles := map[string]string{}
+	if plan.SourceOut != "" {
+		outFiles[plan.SourceOut] = "the source video"
+	}
+	if !keepsSource(j.location) {
+		for _, rel := range j.sidecars {
+			outFiles[filepath.Join(j.out, rel)] = fmt.Sprintf("the sidecar file \"%s\"", filepath.Base(rel))
+		}
+	}
+
+	for _, profile := range j.location.Profiles {
+
+		if profile.When != "" {
+			// a condition that cannot be evaluated for this video, e.g. a field missing in the probe data,
+			// only skips the profile
+			run, err := evalWhen(profile.When, env)
+			if err != nil {
+				j.log.Warnf("skipping profile \"%s\": %v", profile.Name, err)
+				plan.Skipped = append(plan.Skipped, profile.Name)
+				continue
+			}
+			if !run {
+				j.log.Infof("skipping profile \"%s\", condition is false: %s", profile.Name, profile.When)
+				plan.Skipped = append(plan.Skipped, profile.Name)
+				continue
+			}
+		}
+
+		tmplFile, err := tmpl.FindTemplate(vc.Cfg.TmplDirs, profile.Template)
+		if err != nil {
+			return plan, profileErr(config.ErrClassTemplate, profile.Name, err)
+		}
+		j.log.Debugf("using template: \"%s\"", tmplFile)
+		profileTmpl, err := tmpl.NewTmplFromFile(tmplFile)
+		if err != nil {
+			return plan, profileErr(config.ErrClassTemplate, profile.Name, err)
+		}
+		if profile.Name == "" {
+			return plan, classErr(config.ErrClassTemplate, fmt.Errorf("profile name cannot be empty"))
+		}
+
+		// add ffprobe and profile data into the template, the args are extended with the name of the profile
+		args := map[string]string{"Name": profile.Name}
+		for k, v := range profile.Args {
+			args[k] = v
+		}
+		data := videoData{
+			Video:        probeData,
+			Profile:      args,
+			Resource:     j.lease.Slot(profile.Resource),
+			Sidecars:     sidecars,
+			File:         file,
+			Pass:         1,
+			PassLogFile:  passLogFile(j, relDir, file, profile.Name),
+			Measurements: map[string]string{},
+			LocalData:    map[string]interface{}{},
+		}
+		tmplData := templateData{}
+		err = profileTmpl.ParseJson(data, &tmplData)
+		if err != nil {
+			return plan, profileErr(config.ErrClassTemplate, profile.Name, fmt.Errorf("error parsing template: %v", err))
+		}
+		tmplData.Args = dropEmpty(tmplData.Args)
+		tmplData.Init = dropEmpty(tmplData.Init)
+		j.log.Debugf("rendered template: \"%s\"", tmplData)
+
+		// tmp files are stored in the same relative directory as the input video,
+		// their names are unique per profile even if the output names are not
+		tmpFileName := renameFile(filepath.Base(j.video), profile.Name, tmplData.Extension)
+		mainFile, err := directoryMainFile(tmplData)
+		if err != nil {
+			return plan, profileErr(config.ErrClassTemplate, profile.Name, err)
+		}
+		if mainFile != "" {
+			tmpFileName = file.BaseName + "." + profile.Name
+		}
+		tmpFilePath := filepath.Join(j.tmp, relDir, tmpFileName)
+		outFileName, err := outputName(profile, data, tmplData, tmpFileName)
+		if err != nil {
+			return plan, profileErr(config.ErrClassTemplate, profile.Name, err)
+		}
+		outDir, err := outputDir(j, profile, data, relDir)
+		if err != nil {
+			return plan, profileErr(config.ErrClassTemplate, profile.Name, err)
+		}
+		outFilePath := filepath.Join(outDir, outFileName)
+		if other, ok := outFiles[outFilePath]; ok {
+			return plan, profileErr(config.ErrClassTemplate, profile.Name,
+				fmt.Errorf("%s and profile \"%s\" have the same output file \"%s\"", other, profile.Name, outFileName))
+		}
+		outFiles[outFilePath] = fmt.Sprintf("profile \"%s\"", profile.Name)
+
+		r := RenditionPlan{
+			Profile:  profile.Name,
+			Template: tmplFile,
+			TmpFile:  tmpFilePath,
+			OutFile:  outFilePath,
+			MainFile: mainFile,
+			data:     tmplData,
+		}
+		if len(tmplData.Passes) > 0 {
+			if len(tmplData.Args) > 0 {
+				return plan, profileErr(config.ErrClassTemplate, profile.Name, fmt.Errorf("a template can not set both args and passes"))
+			}
+			r.Passes = len(tmplData.Passes)
+			r.template = profileTmpl
+			r.vars = data
+			r.Cmd, err = vc.passCmd(j, r, tmplData, 1)
+		} else {
+			r.Cmd, err = vc.ffmpeg.GetCmd(j.video, r.output(), tmplData.Init, tmplData.Args)
+		}
+		if err != nil {
+			return plan, profileErr(config.ErrClassTemplate, profile.Name, err)
+		}
+		plan.Renditions = append(plan.Renditions, r)
+	}
+	return plan, nil
+}
+
+// whenEnv returns the variables available to the when expressions of the profiles: the probe data as Video,
+// the file info as File, and shorthands for the most used values
+func whenEnv(probe ffprobe.ProbeData, source os.FileInfo, relativePath string) map[string]interface{} {
+	ext := strings.ToLower(strings.TrimPrefix(filepath.Ext(source.Name()), "."))
+	return map[string]interface{}{
+		"Video": probe,
+		"File": map[string]interface{}{
+			"Name": source.Name(),
+			"Path": relativePath,
+			"Ext":  ext,
+			"Size": source.Size(),
+		},
+		"codec":    probe.Summary.Video.Format,
+		"width":    probe.Summary.Video.W,
+		"height":   probe.Summary.Video.H,
+		"bitrate":  probe.Summary.Video.BitRate,
+		"duration": probe.Format.DurationSeconds,
+		"size":     source.Size(),
+		"ext":      ext,
+	}
+}
+
+// evalWhen evaluates the when condition of a profile
+func evalWhen(when string, env map[string]interface{}) (bool, error) {
+	e, err := expr.Compile(when)
+	if err != nil {
+		return false, err
+	}
+	return e.Eval(env)
+}
@@ -0,0 +1,40 @@
+package videoconv
+
+import (
+	"github.com/AndresBott/videoconv/internal/ffmpegtranscode"
+	"time"
+)
+
+// time between two progress log entries of a running ffmpeg
+var progressLogInterval = time.Minute
+
+// logProgress returns a progress callback that logs the progress of an ffmpeg run every progressLogInterval,
+// label names what is running, e.g. the profiles of a group or the pass of a multi-pass rendition
+func logProgress(j *job, label string) ffmpegtranscode.ProgressFunc {
+	last := time.Now()
+	return func(p ffmpegtranscode.Progress) {
+		if p.End || time.Since(last) < progressLogInterval {
+			return
+		}
+		last = time.Now()
+		if p.Percent == 0 {
+			// the duration of the video is unknown
+			j.log.Infof("%s: %s transcoded at %.2fx", label, p.OutTime.Round(time.Second), p.Speed)
+			return
+		}
+		eta := "unknown"
+		if p.ETA > 0 {
+			eta = p.ETA.Round(time.Second).String()
+		}
+		j.log.Infof("%s: %.1f%% (%s) at %.2fx, %d fps, eta %s", label, p.Percent, p.OutTime.Round(time.Second),
+			p.Speed, int(p.Fps), eta)
+	}
+}
+
+// videoDuration returns the duration of the probed video, zero if it is unknown
+func videoDuration(plan VideoPlan) time.Duration {
+	if plan.probe == nil {
+		return 0
+	}
+	return time.Duration(plan.probe.Format.DurationSeconds * float64(time.Second))
+}
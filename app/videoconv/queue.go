@@ -0,0 +1,247 @@
+package videoconv
+
+import (
+	"context"
+	"fmt"
+	"github.com/AndresBott/videoconv/app/videoconv/config"
+	"github.com/AndresBott/videoconv/internal/ffprobe"
+	"github.com/AndresBott/videoconv/internal/resources"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+)
+
+// job is a single video of a location waiting to be processed
+type job struct {
+	id       uint64
+	location config.Location
+	// index of the location in the config, every location has its own limit of parallel jobs,
+	// also when two locations share the same path
+	locationID int
+	// absolute paths of the location, the video and the location directories
+	path  string
+	video string
+	in    string
+	out   string
+	tmp   string
+	fail  string
+	// destinations of the source video, depending on the source action
+	archive string
+	trash   string
+	// files that belong to the video and are moved along with it, relative to the input dir
+	sidecars []string
+
+	// resource slots held for the lifetime of the job
+	lease *resources.Lease
+	// probe data of the video and the profiles whose when condition is false, only known
+	// before the job runs when a conditional profile needs a resource
+	probe *ffprobe.ProbeData
+	skip  map[string]bool
+	log   *log.Entry
+}
+
+var jobCounter uint64
+
+// newJob creates a job for a video, relVideo is relative to the input dir of the location
+func newJob(location config.Location, locationPath string, relVideo string) *job {
+	j := job{
+		id:       atomic.AddUint64(&jobCounter, 1),
+		location: location,
+		path:     locationPath,
+		video:    filepath.Join(locationPath, location.InputDir, relVideo),
+		in:       filepath.Join(locationPath, location.InputDir),
+		out:      filepath.Join(locationPath, location.OutputDir),
+		tmp:      filepath.Join(locationPath, location.TmpDir),
+		fail:     filepath.Join(locationPath, location.FailDir),
+		archive:  filepath.Join(locationPath, location.ArchiveDir),
+		trash:    filepath.Join(locationPath, location.TrashDir),
+	}
+	j.log = log.WithFields(log.Fields{
+		"job":      j.id,
+		"location": location.Path,
+		"video":    relVideo,
+	})
+	return &j
+}
+
+// relPath returns the path of the video relative to the input directory
+func (j *job) relPath() (string, error) {
+	rel, err := filepath.Rel(j.in, j.video)
+	if err != nil {
+		return "", fmt.Errorf("error getting the relative path: %v", err)
+	}
+	return rel, nil
+}
+
+// pools returns the resource pools needed by the profiles of the job that will run
+func (j *job) pools() []string {
+	var pools []string
+	for _, p := range j.location.Profiles {
+		if p.Resource != "" && !j.skip[p.Name] {
+			pools = append(pools, p.Resource)
+		}
+	}
+	return pools
+}
+
+// checkWhen evaluates the when conditions of the profiles that need a resource before the job is queued,
+// so that a profile skipped for the video does not hold a slot, videos that cannot be probed lease all resources
+func (vc *Converter) checkWhen(j *job) {
+	conditional := false
+	for _, p := range j.location.Profiles {
+		if p.Resource != "" && p.When != "" {
+			conditional = true
+		}
+	}
+	if !conditional {
+		return
+	}
+	relativePath, err := j.relPath()
+	if err != nil {
+		return
+	}
+	source, err := os.Stat(j.video)
+	if err != nil {
+		return
+	}
+	probeData, err := vc.ffprobe.Probe(j.video)
+	if err != nil {
+		return
+	}
+	j.probe = &probeData
+	env := whenEnv(probeData, source, relativePath)
+	j.skip = map[string]bool{}
+	for _, p := range j.location.Profiles {
+		if p.Resource == "" || p.When == "" {
+			continue
+		}
+		if run, err := evalWhen(p.When, env); err != nil || !run {
+			j.skip[p.Name] = true
+		}
+	}
+}
+
+// jobQueue hands out jobs in order to a set of workers while respecting the amount
+// of jobs allowed to run in parallel on every location and the available resources
+type jobQueue struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	pending   []*job
+	running   map[int]int
+	limits    map[int]int
+	resources *resources.Manager
+}
+
+// newJobQueue creates a queue, limits contains the max amount of parallel jobs per location index,
+// locations not present or with a limit of 0 are only limited by the amount of workers
+func newJobQueue(jobs []*job, limits map[int]int, res *resources.Manager) *jobQueue {
+	q := jobQueue{
+		pending:   jobs,
+		running:   map[int]int{},
+		limits:    limits,
+		resources: res,
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return &q
+}
+
+// next returns the first pending job whose location has free capacity and whose resources can be leased,
+// it blocks while no pending job can run, and returns false once there is no job left
+func (q *jobQueue) next() (*job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		if len(q.pending) == 0 {
+			return nil, false
+		}
+		for i, j := range q.pending {
+			limit := q.limits[j.locationID]
+			if limit > 0 && q.running[j.locationID] >= limit {
+				continue
+			}
+			if pools := j.pools(); len(pools) > 0 && q.resources != nil {
+				lease, ok, err := q.resources.TryAcquire(pools...)
+				if err != nil {
+					j.log.Warnf("unable to lease resources, running without: %v", err)
+				} else if !ok {
+					continue
+				}
+				j.lease = lease
+			}
+			q.pending = append(q.pending[:i], q.pending[i+1:]...)
+			q.running[j.locationID]++
+			return j, true
+		}
+		q.cond.Wait()
+	}
+}
+
+// done marks a job as finished, freeing the capacity of its location and its resources
+func (q *jobQueue) done(j *job) {
+	q.mu.Lock()
+	q.running[j.locationID]--
+	j.lease.Release()
+	j.lease = nil
+	q.mu.Unlock()
+	q.cond.Broadcast()
+}
+
+// runJobs processes all the jobs using the configured amount of workers and blocks until all are done,
+// once the context is canceled no new jobs are started. With StopOnError the first failed video
+// interrupts all the other jobs.
+func (vc *Converter) runJobs(ctx context.Context, jobs []*job, result *RunResult) {
+	if len(jobs) == 0 {
+		return
+	}
+	limits := map[int]int{}
+	for i, location := range vc.Cfg.Locations {
+		limits[i] = location.Workers
+	}
+	for _, j := range jobs {
+		vc.checkWhen(j)
+	}
+	q := newJobQueue(jobs, limits, vc.resources)
+
+	workers := vc.Cfg.Workers
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(jobs) {
+		workers = len(jobs)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				j, ok := q.next()
+				if !ok {
+					return
+				}
+				if ctx.Err() != nil {
+					q.done(j)
+					continue
+				}
+				if processFn != nil {
+					processFn(j.video, j.in, j.out, j.tmp, j.fail, j.location.Profiles) // used for testing purposes
+				} else {
+					res := vc.processVideo(ctx, j)
+					result.add(res)
+					if res.Outcome == OutcomeFailed && vc.StopOnError {
+						j.log.Warn("stopping after the first failed video")
+						cancel()
+					}
+				}
+				q.done(j)
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,134 @@
+package videoconv
+
+import (
+	"github.com/AndresBott/videoconv/app/videoconv/config"
+	"github.com/AndresBott/videoconv/internal/resources"
+	"github.com/google/go-cmp/cmp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestJobQueue(t *testing.T) {
+	locA := config.Location{Path: "a"}
+	locB := config.Location{Path: "b"}
+	// a second location on the same path has its own limit
+	locC := config.Location{Path: "a"}
+
+	queued := func(id int, location config.Location, video string) *job {
+		j := newJob(location, "/tmp/"+location.Path, video)
+		j.locationID = id
+		return j
+	}
+	jobs := []*job{
+		queued(0, locA, "1.mkv"),
+		queued(0, locA, "2.mkv"),
+		queued(1, locB, "3.mkv"),
+		queued(2, locC, "5.mkv"),
+		queued(0, locA, "4.mkv"),
+	}
+	q := newJobQueue(jobs, map[int]int{0: 1, 2: 1}, nil)
+
+	var mu sync.Mutex
+	running := map[string]int{}
+	maxRunning := map[string]int{}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				j, ok := q.next()
+				if !ok {
+					return
+				}
+				mu.Lock()
+				running[j.location.Path]++
+				if running[j.location.Path] > maxRunning[j.location.Path] {
+					maxRunning[j.location.Path] = running[j.location.Path]
+				}
+				mu.Unlock()
+
+				time.Sleep(10 * time.Millisecond)
+
+				mu.Lock()
+				running[j.location.Path]--
+				mu.Unlock()
+				q.done(j)
+			}
+		}()
+	}
+	wg.Wait()
+
+	want := map[string]int{
+		"a": 2,
+		"b": 1,
+	}
+	if diff := cmp.Diff(maxRunning, want); diff != "" {
+		t.Errorf("unexpected value (-got +want)\n%s", diff)
+	}
+}
+
+func TestJobQueueResources(t *testing.T) {
+	res, err := resources.New(map[string][]resources.Item{
+		"gpu": {{Name: "renderD128", Device: "/dev/dri/renderD128", Capacity: 1}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gpuLoc := config.Location{Path: "gpu", Profiles: []config.Profile{{Name: "h265", Resource: "gpu"}}}
+	cpuLoc := config.Location{Path: "cpu", Profiles: []config.Profile{{Name: "h264"}}}
+	jobs := []*job{
+		newJob(gpuLoc, "/tmp/gpu", "1.mkv"),
+		newJob(gpuLoc, "/tmp/gpu", "2.mkv"),
+		newJob(cpuLoc, "/tmp/cpu", "3.mkv"),
+	}
+	q := newJobQueue(jobs, nil, res)
+
+	j1, _ := q.next()
+	if j1.lease.Slot("gpu").Device != "/dev/dri/renderD128" {
+		t.Fatalf("expected first job to lease the gpu, got: %v", j1.lease.Slot("gpu"))
+	}
+
+	// the gpu is busy, so the cpu job is picked next
+	j2, _ := q.next()
+	if j2.location.Path != "cpu" {
+		t.Fatalf("expected the cpu job, got: %s", j2.location.Path)
+	}
+
+	q.done(j1)
+	j3, _ := q.next()
+	if j3.location.Path != "gpu" || j3.lease.Slot("gpu").Name != "renderD128" {
+		t.Fatalf("expected the second gpu job after release, got: %s", j3.location.Path)
+	}
+}
+
+func TestJobQueueWhen(t *testing.T) {
+	vc, _ := newVideConv(t)
+	location := &vc.Cfg.Locations[0]
+	location.Profiles = []config.Profile{
+		{Name: "hd", Template: "empty", Resource: "gpu", When: "height >= 1080"},
+		{Name: "small", Template: "empty", Resource: "cpu", When: "height < 1080"},
+		{Name: "mkv", Template: "mkv", Resource: "disk"},
+	}
+	jobs, err := vc.locationJobs(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, j := range jobs {
+		vc.checkWhen(j)
+		// the sample video is smaller than 1080p so the hd profile does not need the gpu,
+		// the other file cannot be probed and keeps all its resources
+		want := []string{"gpu", "cpu", "disk"}
+		if strings.HasSuffix(j.video, "video.mp4") {
+			want = []string{"cpu", "disk"}
+		}
+		if diff := cmp.Diff(j.pools(), want); diff != "" {
+			t.Errorf("unexpected value (-got +want)\n%s", diff)
+		}
+	}
+}
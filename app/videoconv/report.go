@@ -0,0 +1,105 @@
+package videoconv
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/AndresBott/videoconv/internal/ffmpegtranscode"
+	"github.com/AndresBott/videoconv/internal/ffprobe"
+	"os"
+	"time"
+)
+
+// reportSuffix is appended to the name of a failed video to store the failure report next to it
+const reportSuffix = ".videoconv-error.json"
+
+// failureReport describes why a video was moved to the fail dir
+type failureReport struct {
+	Video    string `json:"video"`
+	Location string `json:"location"`
+	Error    string `json:"error"`
+	Class    string `json:"class"`
+
+	// profile that failed, empty if the failure does not belong to a profile, e.g. ffprobe errors
+	Profile      string        `json:"profile,omitempty"`
+	Template     string        `json:"template,omitempty"`
+	TemplateData *templateData `json:"template_data,omitempty"`
+	Cmd          []string      `json:"cmd,omitempty"`
+	ExitCode     int           `json:"exit_code,omitempty"`
+	Stderr       []string      `json:"stderr,omitempty"`
+
+	Probe    *probeSummary `json:"probe,omitempty"`
+	Attempts int           `json:"attempts"`
+	Started  time.Time     `json:"started,omitempty"`
+	Failed   time.Time     `json:"failed"`
+}
+
+// probeSummary is the relevant part of the ffprobe data of a video
+type probeSummary struct {
+	Format   string        `json:"format"`
+	Duration float64       `json:"duration"`
+	Size     string        `json:"size"`
+	Streams  int           `json:"streams"`
+	Video    ffprobe.Video `json:"video"`
+}
+
+// newFailureReport collects the information about the failure of a job, cmd is the ffmpeg command that failed,
+// e.g. the merged command of a single decode run or the pass of a multi-pass rendition, if any ran
+func newFailureReport(j *job, plan VideoPlan, rec *jobRecord, cmd ffmpegtranscode.CmdArgs, err error) failureReport {
+	class, profile := classify(err)
+	report := failureReport{
+		Video:    j.video,
+		Location: j.location.Path,
+		Error:    err.Error(),
+		Class:    class,
+		Profile:  profile,
+		Failed:   time.Now(),
+	}
+	if rec != nil {
+		report.Attempts = rec.Attempts
+		report.Started = rec.Started
+	}
+
+	if plan.probe != nil {
+		report.Probe = &probeSummary{
+			Format:   plan.probe.Format.FormatName,
+			Duration: plan.probe.Format.DurationSeconds,
+			Size:     plan.probe.Format.Size,
+			Streams:  plan.probe.Format.NBStreams,
+			Video:    plan.probe.Summary.Video,
+		}
+	}
+
+	// the template name is all we know if the template could not be rendered
+	for _, p := range j.location.Profiles {
+		if p.Name == profile {
+			report.Template = p.Template
+		}
+	}
+	for _, r := range plan.Renditions {
+		if r.Profile == profile {
+			data := r.data
+			report.Template = r.Template
+			report.TemplateData = &data
+			report.Cmd = r.Cmd.Slice()
+		}
+	}
+	if cmd.Ffmpeg != "" {
+		report.Cmd = cmd.Slice()
+	}
+
+	var execErr *ffmpegtranscode.ExecError
+	if errors.As(err, &execErr) {
+		report.ExitCode = execErr.ExitCode
+		report.Stderr = execErr.Stderr
+	}
+	return report
+}
+
+// write stores the report as json
+func (r failureReport) write(file string) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(file, b, 0644)
+}
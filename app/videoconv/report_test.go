@@ -0,0 +1,109 @@
+package videoconv
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/AndresBott/videoconv/app/videoconv/config"
+	"github.com/google/go-cmp/cmp"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFailureReport(t *testing.T) {
+	log.SetOutput(io.Discard) // discard the log entries
+	vc, tmpPath := newVideConv(t)
+	vc.Cfg.LogLevel = "info"
+	fakeFfmpeg(t, vc, "echo \"invalid argument\" >&2\necho \"conversion failed\" >&2\nexit 3")
+
+	location := vc.Cfg.Locations[0]
+	location.Profiles = []config.Profile{
+		{Name: "test", Template: "mkv"},
+	}
+	vc.processVideo(context.Background(), newJob(location, tmpPath, "nested/video.mp4"))
+
+	b, err := os.ReadFile(filepath.Join(tmpPath, "fail/nested/video.mp4"+reportSuffix))
+	if err != nil {
+		t.Fatalf("expected a failure report: %v", err)
+	}
+	got := failureReport{}
+	err = json.Unmarshal(b, &got)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got.Profile != "test" || got.Class != config.ErrClassFfmpeg || got.ExitCode != 3 || got.Attempts != 1 {
+		t.Errorf("unexpected report: %+v", got)
+	}
+	if diff := cmp.Diff(got.Stderr, []string{"invalid argument", "conversion failed"}); diff != "" {
+		t.Errorf("unexpected value (-got +want)\n%s", diff)
+	}
+	if got.Template != filepath.Join(filepath.Dir(tmpPath), "templates/mkv.tmpl.json") {
+		t.Errorf("unexpected template: %s", got.Template)
+	}
+	if got.TemplateData == nil || got.TemplateData.Extension != "mkv" {
+		t.Errorf("unexpected template data: %+v", got.TemplateData)
+	}
+	if len(got.Cmd) == 0 || got.Cmd[len(got.Cmd)-1] != filepath.Join(tmpPath, "tmp/nested/video.test.mkv") {
+		t.Errorf("unexpected command: %v", got.Cmd)
+	}
+	if got.Probe == nil || got.Started.IsZero() || got.Failed.IsZero() {
+		t.Errorf("expected probe summary and timestamps: %+v", got)
+	}
+}
+
+func TestFailureReportCmd(t *testing.T) {
+	log.SetOutput(io.Discard) // discard the log entries
+
+	tcs := []struct {
+		name     string
+		profiles []config.Profile
+		// expected in the command of the report
+		args []string
+	}{
+		{
+			name:     "second pass",
+			profiles: []config.Profile{{Name: "test", Template: "twopass"}},
+			args:     []string{"-pass", "2"},
+		},
+		{
+			name:     "single decode",
+			profiles: []config.Profile{{Name: "a", Template: "empty"}, {Name: "test", Template: "mkv"}},
+			args:     []string{"video.a.mp4", "video.test.mkv"},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			vc, tmpPath := newVideConv(t)
+			vc.Cfg.LogLevel = "info"
+			// analysis passes succeed, everything else fails
+			_, files := runJob(t, vc, tmpPath, "[ \"$last\" = - ] || exit 1", func(location *config.Location) {
+				location.SingleDecode = true
+				location.Profiles = tc.profiles
+			}, "fail")
+			if _, ok := files["fail/nested/video.mp4"+reportSuffix]; !ok {
+				t.Fatalf("expected a failure report, got: %v", fileNames(files))
+			}
+
+			b, err := os.ReadFile(filepath.Join(tmpPath, "fail/nested/video.mp4"+reportSuffix))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := failureReport{}
+			err = json.Unmarshal(b, &got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cmd := strings.Join(got.Cmd, " ")
+			for _, a := range tc.args {
+				if !strings.Contains(cmd, a) {
+					t.Errorf("expected the command to contain \"%s\", got: %s", a, cmd)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package videoconv
+
+import (
+	"sync"
+	"time"
+)
+
+// outcomes of processing a single video
+const (
+	OutcomeDone        = "done"        // all renditions are published and the source moved to the output dir
+	OutcomeFailed      = "failed"      // the video was moved to the fail dir, or could not be moved there
+	OutcomeRetry       = "retry"       // the video failed and is tried again later
+	OutcomeWaiting     = "waiting"     // the video is waiting for the backoff of a previous failure
+	OutcomeSkipped     = "skipped"     // the video failed in a previous run and was not processed again
+	OutcomeInterrupted = "interrupted" // the run was stopped, the video stays in the input directory
+)
+
+// VideoResult is the outcome of processing a single video
+type VideoResult struct {
+	Location string    `json:"location"`
+	Video    string    `json:"video"`
+	Outcome  string    `json:"outcome"`
+	Error    string    `json:"error,omitempty"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+}
+
+// RunResult summarizes a run. The counters cover all the passes until the run stopped, Videos and Errors
+// only the last pass, so a daemon running for months does not keep every video it ever saw.
+type RunResult struct {
+	// the videos of the last pass that were done, failed or interrupted
+	Videos      []VideoResult `json:"videos"`
+	Done        int           `json:"done"`
+	Failed      int           `json:"failed"`
+	Retry       int           `json:"retry"`
+	Waiting     int           `json:"waiting"`
+	Skipped     int           `json:"skipped"`
+	Interrupted int           `json:"interrupted"`
+	// errors that don't belong to a single video, e.g. a location that is not accessible
+	Errors []string `json:"errors,omitempty"`
+
+	mu sync.Mutex
+}
+
+// newPass forgets the videos and errors of the previous pass, the counters are kept
+func (r *RunResult) newPass() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Videos = nil
+	r.Errors = nil
+}
+
+// add records the result of a video, it is safe to be called from several workers
+func (r *RunResult) add(v VideoResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch v.Outcome {
+	case OutcomeDone:
+		r.Done++
+		r.Videos = append(r.Videos, v)
+	case OutcomeFailed:
+		r.Failed++
+		r.Videos = append(r.Videos, v)
+	case OutcomeInterrupted:
+		r.Interrupted++
+		r.Videos = append(r.Videos, v)
+	case OutcomeRetry:
+		r.Retry++
+	case OutcomeWaiting:
+		r.Waiting++
+	case OutcomeSkipped:
+		r.Skipped++
+	}
+}
+
+// addError records an error that does not belong to a single video
+func (r *RunResult) addError(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Errors = append(r.Errors, err.Error())
+}
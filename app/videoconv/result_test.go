@@ -0,0 +1,121 @@
+package videoconv
+
+import (
+	"context"
+	"github.com/AndresBott/videoconv/app/videoconv/config"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunResult(t *testing.T) {
+	log.SetOutput(io.Discard) // discard the log entries
+
+	tcs := []struct {
+		name        string
+		stopOnError bool
+		done        int
+		failed      int
+	}{
+		{
+			name:   "process all videos",
+			done:   1,
+			failed: 2,
+		},
+		{
+			name:        "stop on error",
+			stopOnError: true,
+			done:        0,
+			failed:      1,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			vc, tmpPath := newVideConv(t)
+			fakeFfmpeg(t, vc, "echo done > \"$last\"")
+			vc.StopOnError = tc.stopOnError
+			vc.Cfg.Locations[0].Profiles = []config.Profile{
+				{Name: "test", Template: "empty"},
+			}
+
+			// a video that can not be probed, found before the others
+			err := os.WriteFile(filepath.Join(tmpPath, "in/a.mp4"), []byte("broken"), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := vc.Run()
+			if got.Done != tc.done || got.Failed != tc.failed {
+				t.Errorf("expected %d done and %d failed, got: %d done and %d failed", tc.done, tc.failed, got.Done, got.Failed)
+			}
+			if len(got.Videos) != tc.done+tc.failed {
+				t.Errorf("expected %d video results, got: %d", tc.done+tc.failed, len(got.Videos))
+			}
+			for _, v := range got.Videos {
+				if v.Outcome == OutcomeFailed && v.Error == "" {
+					t.Errorf("expected an error for failed video %s", v.Video)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessVideoFailDirError(t *testing.T) {
+	log.SetOutput(io.Discard) // discard the log entries
+	vc, tmpPath := newVideConv(t)
+	fakeFfmpeg(t, vc, "exit 1")
+
+	// the fail dir can not be created if a file is in the way
+	err := os.RemoveAll(filepath.Join(tmpPath, "fail"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(tmpPath, "fail"), []byte("not a dir"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	location := vc.Cfg.Locations[0]
+	location.Profiles = []config.Profile{
+		{Name: "test", Template: "empty"},
+	}
+
+	got := vc.processVideo(context.Background(), newJob(location, tmpPath, "nested/video.mp4"))
+	if got.Outcome != OutcomeFailed || got.Error == "" {
+		t.Errorf("unexpected result: %+v", got)
+	}
+	if _, err := os.Stat(filepath.Join(tmpPath, "in/nested/video.mp4")); err != nil {
+		t.Errorf("expected the video to stay in the input dir: %v", err)
+	}
+
+	// the video is not processed again until it changes
+	got = vc.processVideo(context.Background(), newJob(location, tmpPath, "nested/video.mp4"))
+	if got.Outcome != OutcomeSkipped {
+		t.Errorf("expected the video to be skipped, got: %+v", got)
+	}
+}
+
+func TestRunResultPasses(t *testing.T) {
+	r := &RunResult{}
+	for pass := 0; pass < 3; pass++ {
+		r.newPass()
+		r.add(VideoResult{Video: "done.mp4", Outcome: OutcomeDone})
+		r.add(VideoResult{Video: "waiting.mp4", Outcome: OutcomeWaiting})
+		r.add(VideoResult{Video: "skipped.mp4", Outcome: OutcomeSkipped})
+		r.addError(os.ErrPermission)
+	}
+
+	if r.Done != 3 || r.Waiting != 3 || r.Skipped != 3 {
+		t.Errorf("expected the counters of all passes, got: %d done, %d waiting and %d skipped", r.Done, r.Waiting, r.Skipped)
+	}
+	// only the done video of the last pass is kept
+	if len(r.Videos) != 1 || r.Videos[0].Video != "done.mp4" {
+		t.Errorf("unexpected video results: %+v", r.Videos)
+	}
+	if len(r.Errors) != 1 {
+		t.Errorf("expected the errors of the last pass, got: %v", r.Errors)
+	}
+}
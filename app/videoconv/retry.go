@@ -0,0 +1,91 @@
+package videoconv
+
+import (
+	"errors"
+	"github.com/AndresBott/videoconv/app/videoconv/config"
+	"time"
+)
+
+// jobError adds the error class and the profile that failed to an error, the class is used to
+// decide if a failed video is retried
+type jobError struct {
+	class   string
+	profile string
+	err     error
+}
+
+func (e *jobError) Error() string {
+	return e.err.Error()
+}
+
+func (e *jobError) Unwrap() error {
+	return e.err
+}
+
+// classErr wraps err into a jobError of the given class, nil errors are returned as is
+func classErr(class string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &jobError{class: class, err: err}
+}
+
+// profileErr wraps err into a jobError of the given class that belongs to a profile
+func profileErr(class, profile string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &jobError{class: class, profile: profile, err: err}
+}
+
+// classify returns the class and the profile of an error, errors without class are filesystem errors
+func classify(err error) (string, string) {
+	var jErr *jobError
+	if errors.As(err, &jErr) {
+		return jErr.class, jErr.profile
+	}
+	return config.ErrClassIO, ""
+}
+
+// retryPolicy returns the policy for a failure in the profile, or the one of the location if the
+// profile does not define its own or the failure does not belong to a profile
+func retryPolicy(location config.Location, profile string) config.RetryPolicy {
+	for _, p := range location.Profiles {
+		if p.Name == profile && p.Retry != nil {
+			return *p.Retry
+		}
+	}
+	return location.Retry
+}
+
+// shouldRetry decides if a video that failed after the given amount of attempts is tried again,
+// the returned duration is the time to wait before the next attempt
+func shouldRetry(policy config.RetryPolicy, class string, attempts int) (bool, time.Duration) {
+	if attempts >= policy.MaxAttempts {
+		return false, 0
+	}
+	retryable := false
+	for _, c := range policy.RetryOn {
+		if c == class {
+			retryable = true
+			break
+		}
+	}
+	if !retryable {
+		return false, 0
+	}
+
+	// exponential backoff, the config rejects a max backoff of 0 but a policy built in code can have it
+	maxBackoff := policy.MaxBackoff
+	if maxBackoff <= 0 {
+		maxBackoff = config.DefaultRetryPolicy().MaxBackoff
+	}
+	wait := policy.Backoff
+	for i := 1; i < attempts && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > maxBackoff {
+		wait = maxBackoff
+	}
+	return true, wait
+}
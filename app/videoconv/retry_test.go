@@ -0,0 +1,187 @@
+package videoconv
+
+import (
+	"context"
+	"fmt"
+	"github.com/AndresBott/videoconv/app/videoconv/config"
+	"github.com/google/go-cmp/cmp"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestShouldRetry(t *testing.T) {
+	policy := config.RetryPolicy{
+		MaxAttempts: 5,
+		Backoff:     time.Minute,
+		MaxBackoff:  5 * time.Minute,
+		RetryOn:     []string{config.ErrClassFfmpeg},
+	}
+
+	tcs := []struct {
+		name     string
+		class    string
+		attempts int
+		retry    bool
+		wait     time.Duration
+	}{
+		{name: "first failure", class: config.ErrClassFfmpeg, attempts: 1, retry: true, wait: time.Minute},
+		{name: "backoff doubles", class: config.ErrClassFfmpeg, attempts: 3, retry: true, wait: 4 * time.Minute},
+		{name: "backoff is capped", class: config.ErrClassFfmpeg, attempts: 4, retry: true, wait: 5 * time.Minute},
+		{name: "attempts exhausted", class: config.ErrClassFfmpeg, attempts: 5, retry: false},
+		{name: "class not retried", class: config.ErrClassTemplate, attempts: 1, retry: false},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			retry, wait := shouldRetry(policy, tc.class, tc.attempts)
+			if retry != tc.retry {
+				t.Errorf("expected retry to be %v", tc.retry)
+			}
+			if wait != tc.wait {
+				t.Errorf("expected wait %s, got %s", tc.wait, wait)
+			}
+		})
+	}
+}
+
+func TestShouldRetryMaxBackoff(t *testing.T) {
+	// without max backoff the doubling stops at the default one instead of overflowing
+	policy := config.RetryPolicy{
+		MaxAttempts: 1000,
+		Backoff:     time.Minute,
+		RetryOn:     []string{config.ErrClassFfmpeg},
+	}
+	for _, attempts := range []int{7, 100, 999} {
+		retry, wait := shouldRetry(policy, config.ErrClassFfmpeg, attempts)
+		if !retry || wait != config.DefaultRetryPolicy().MaxBackoff {
+			t.Errorf("expected a retry after %s for attempt %d, got: %v, %s", config.DefaultRetryPolicy().MaxBackoff, attempts, retry, wait)
+		}
+	}
+}
+
+func TestClassify(t *testing.T) {
+	err := fmt.Errorf("wrapped: %w", profileErr(config.ErrClassFfmpeg, "hd", fmt.Errorf("exit status 1")))
+	class, profile := classify(err)
+	if class != config.ErrClassFfmpeg || profile != "hd" {
+		t.Errorf("unexpected class \"%s\" and profile \"%s\"", class, profile)
+	}
+
+	class, profile = classify(fmt.Errorf("plain error"))
+	if class != config.ErrClassIO || profile != "" {
+		t.Errorf("unexpected class \"%s\" and profile \"%s\"", class, profile)
+	}
+}
+
+func TestProcessVideoRetry(t *testing.T) {
+	log.SetOutput(io.Discard) // discard the log entries
+	vc, tmpPath := newVideConv(t)
+	vc.Cfg.LogLevel = "info"
+	edit := func(location *config.Location) {
+		location.Retry = config.RetryPolicy{
+			MaxAttempts: 2,
+			Backoff:     time.Hour,
+			RetryOn:     []string{config.ErrClassFfmpeg},
+		}
+	}
+	jr := newJournal(filepath.Join(tmpPath, "tmp"))
+
+	// the first failure keeps the video in the input directory
+	got, files := runJob(t, vc, tmpPath, "exit 1", edit, "in/nested", "fail")
+	if got.Outcome != OutcomeRetry {
+		t.Errorf("unexpected result: %+v", got)
+	}
+	if diff := cmp.Diff(fileNames(files), []string{"in/nested/video.mp4"}); diff != "" {
+		t.Errorf("unexpected value (-got +want)\n%s", diff)
+	}
+	source, err := os.Stat(filepath.Join(tmpPath, "in/nested/video.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := jr.load("nested/video.mp4", source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Status != statusPending || rec.Attempts != 1 || rec.NextAttempt.IsZero() {
+		t.Fatalf("unexpected record after the first attempt: %+v", rec)
+	}
+	if rec.profile("test").Status != statusFailed {
+		t.Errorf("expected the profile to be failed, got: %s", rec.profile("test").Status)
+	}
+
+	// the video is not processed during the backoff
+	got, _ = runJob(t, vc, tmpPath, "exit 1", edit)
+	if got.Outcome != OutcomeWaiting {
+		t.Errorf("expected the video to wait for the backoff, got: %+v", got)
+	}
+	rec, err = jr.load("nested/video.mp4", source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Attempts != 1 {
+		t.Errorf("expected the video to be skipped during backoff, attempts: %d", rec.Attempts)
+	}
+
+	// once all attempts are used the video is moved to the fail dir
+	rec.NextAttempt = time.Now().Add(-time.Second)
+	err = jr.save(rec)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, files = runJob(t, vc, tmpPath, "exit 1", edit, "in/nested", "fail")
+	want := []string{"fail/nested/video.mp4", "fail/nested/video.mp4.videoconv-error.json"}
+	if diff := cmp.Diff(fileNames(files), want); diff != "" {
+		t.Errorf("unexpected value (-got +want)\n%s", diff)
+	}
+	if _, err := os.Stat(jr.file("nested/video.mp4")); !os.IsNotExist(err) {
+		t.Errorf("expected the journal record to be removed")
+	}
+}
+
+func TestProcessVideoRetryInterrupted(t *testing.T) {
+	log.SetOutput(io.Discard) // discard the log entries
+	vc, tmpPath := newVideConv(t)
+	vc.Cfg.LogLevel = "info"
+	fakeFfmpeg(t, vc, "exec sleep 30")
+
+	location := vc.Cfg.Locations[0]
+	location.Retry = config.RetryPolicy{
+		MaxAttempts: 2,
+		Backoff:     time.Hour,
+		RetryOn:     []string{config.ErrClassFfmpeg},
+	}
+	location.Profiles = []config.Profile{
+		{Name: "test", Template: "empty"},
+	}
+
+	// interrupted attempts, e.g. by restarts, are not counted
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		got := vc.processVideo(ctx, newJob(location, tmpPath, "nested/video.mp4"))
+		cancel()
+		if got.Outcome != OutcomeInterrupted {
+			t.Fatalf("unexpected result: %+v", got)
+		}
+	}
+	source, err := os.Stat(filepath.Join(tmpPath, "in/nested/video.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec, err := newJournal(filepath.Join(tmpPath, "tmp")).load("nested/video.mp4", source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Attempts != 0 || rec.profile("test").Attempts != 0 {
+		t.Errorf("expected no attempts, got: %d and %d of the profile", rec.Attempts, rec.profile("test").Attempts)
+	}
+
+	// the first real failure is retried
+	fakeFfmpeg(t, vc, "exit 1")
+	got := vc.processVideo(context.Background(), newJob(location, tmpPath, "nested/video.mp4"))
+	if got.Outcome != OutcomeRetry {
+		t.Errorf("expected the video to be retried, got: %+v", got)
+	}
+}
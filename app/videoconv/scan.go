@@ -0,0 +1,181 @@
+package videoconv
+
+import (
+	"github.com/AndresBott/videoconv/app/videoconv/config"
+	"github.com/AndresBott/videoconv/internal/glob"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// videoFilter decides which files of an input directory are processed
+type videoFilter struct {
+	extensions []string
+	// glob patterns on the slash separated path relative to the input dir
+	include        []string
+	exclude        []string
+	skipHidden     bool
+	minSize        int64
+	followSymlinks bool
+	// files still being uploaded, e.g. movie.mkv.part, are never videos
+	uploadSuffixes []string
+	// detects videos by content instead of by extension, nil uses the extensions
+	detector *mediaDetector
+}
+
+// newVideoFilter creates the filter of a location, the global extensions are used if the location has none,
+// the detector is only used if the location detects videos by content
+func newVideoFilter(location config.Location, extensions []string, detector *mediaDetector) videoFilter {
+	if location.VideoExtensions != nil {
+		extensions = location.VideoExtensions
+	}
+	f := videoFilter{
+		extensions:     extensions,
+		include:        location.Include,
+		exclude:        location.Exclude,
+		skipHidden:     location.SkipHidden,
+		minSize:        location.MinSize,
+		followSymlinks: location.FollowSymlinks,
+		uploadSuffixes: location.UploadSuffixes,
+	}
+	if location.Detect == config.DetectContent {
+		f.detector = detector
+	}
+	return f
+}
+
+// skipDir checks if a directory is not searched
+func (f videoFilter) skipDir(rel string) bool {
+	if f.skipHidden && strings.HasPrefix(filepath.Base(rel), ".") {
+		return true
+	}
+	return matchAny(f.exclude, rel)
+}
+
+// accept checks if the file is a video that should be processed, file is the absolute path
+func (f videoFilter) accept(file, rel string, fInfo os.FileInfo) bool {
+	if f.skipHidden && strings.HasPrefix(fInfo.Name(), ".") {
+		return false
+	}
+	if len(f.include) > 0 && !matchAny(f.include, rel) {
+		return false
+	}
+	if matchAny(f.exclude, rel) {
+		return false
+	}
+	if fInfo.Size() < f.minSize {
+		return false
+	}
+	// a partial upload can look like a video to the content detection
+	if isUpload(rel, f.uploadSuffixes) {
+		return false
+	}
+	return f.isMedia(file, rel, fInfo)
+}
+
+// leftover checks if the file is not a video and not excluded from the search
+func (f videoFilter) leftover(file, rel string, fInfo os.FileInfo) bool {
+	if f.skipHidden && strings.HasPrefix(fInfo.Name(), ".") {
+		return false
+	}
+	if matchAny(f.exclude, rel) {
+		return false
+	}
+	if isUpload(rel, f.uploadSuffixes) {
+		return true
+	}
+	return !f.isMedia(file, rel, fInfo)
+}
+
+// isMedia checks the type of the file, the content is only checked once the cheap checks passed
+func (f videoFilter) isMedia(file, rel string, fInfo os.FileInfo) bool {
+	if f.detector != nil {
+		return f.detector.isVideo(file, fInfo)
+	}
+	ext := strings.TrimPrefix(filepath.Ext(rel), ".")
+	return ext != "" && isVideo(ext, f.extensions)
+}
+
+func matchAny(patterns []string, rel string) bool {
+	for _, p := range patterns {
+		if glob.Match(p, filepath.ToSlash(rel)) {
+			return true
+		}
+	}
+	return false
+}
+
+// scanResult contains the paths of the files found in an input dir, relative to it
+type scanResult struct {
+	videos []string
+	// files that are not videos, e.g. .nfo or .srt files
+	leftovers []string
+}
+
+// scanInput recursively searches the videos and leftover files in the rootPath
+func scanInput(rootPath string, filter videoFilter) (scanResult, error) {
+	var found scanResult
+	visited := map[string]bool{}
+	err := walkDir(rootPath, "", filter, visited, &found)
+	if err != nil {
+		return scanResult{}, err
+	}
+	return found, nil
+}
+
+// walkDir searches the directory rootPath/rel, symlinked directories are only followed once to avoid loops
+func walkDir(rootPath, rel string, filter videoFilter, visited map[string]bool, found *scanResult) error {
+	dir := filepath.Join(rootPath, rel)
+	if real, err := filepath.EvalSymlinks(dir); err == nil {
+		if visited[real] {
+			return nil
+		}
+		visited[real] = true
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		entryRel := filepath.Join(rel, entry.Name())
+		fInfo, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		if fInfo.Mode()&os.ModeSymlink != 0 {
+			if !filter.followSymlinks {
+				continue
+			}
+			fInfo, err = os.Stat(filepath.Join(rootPath, entryRel))
+			if err != nil {
+				log.Warnf("skipping broken symlink \"%s\": %v", entryRel, err)
+				continue
+			}
+		}
+
+		if fInfo.IsDir() {
+			if filter.skipDir(entryRel) {
+				continue
+			}
+			err = walkDir(rootPath, entryRel, filter, visited, found)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if !fInfo.Mode().IsRegular() {
+			continue
+		}
+		file := filepath.Join(rootPath, entryRel)
+		if filter.accept(file, entryRel, fInfo) {
+			found.videos = append(found.videos, entryRel)
+		} else if filter.leftover(file, entryRel, fInfo) {
+			found.leftovers = append(found.leftovers, entryRel)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+package videoconv
+
+import (
+	"fmt"
+	"github.com/AndresBott/videoconv/app/videoconv/config"
+	"github.com/AndresBott/videoconv/internal/ffprobe"
+	"github.com/google/go-cmp/cmp"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindVideos(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in")
+	files := map[string]string{
+		"in/movie.mkv":         "0123456789",
+		"in/small.mkv":         "0",
+		"in/movie.trailer.mkv": "0123456789",
+		"in/noext":             "0123456789",
+		"in/notes.txt":         "0123456789",
+		"in/.hidden.mkv":       "0123456789",
+		"in/.Trash/a.mkv":      "0123456789",
+		"in/@eaDir/b.mkv":      "0123456789",
+		"in/show/sample/c.mkv": "0123456789",
+		"in/show/d.MKV":        "0123456789",
+		"other/e.mkv":          "0123456789",
+	}
+	for f, content := range files {
+		err := os.MkdirAll(filepath.Dir(filepath.Join(dir, f)), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(filepath.Join(dir, f), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := os.Symlink(filepath.Join(in, "movie.mkv"), filepath.Join(in, "link.mkv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Symlink(filepath.Join(dir, "other"), filepath.Join(in, "linkdir"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a loop must not be followed forever
+	err = os.Symlink(in, filepath.Join(dir, "other", "loop"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	base := config.Location{
+		Exclude:    []string{"**/@eaDir/**", "**/sample/**", "*.trailer.*"},
+		SkipHidden: true,
+		MinSize:    5,
+	}
+
+	tcs := []struct {
+		name      string
+		modify    func(l *config.Location)
+		expect    []string
+		leftovers []string
+	}{
+		{
+			name:      "defaults",
+			expect:    []string{"movie.mkv", "show/d.MKV"},
+			leftovers: []string{"noext", "notes.txt"},
+		},
+		{
+			name: "follow symlinks",
+			modify: func(l *config.Location) {
+				l.FollowSymlinks = true
+			},
+			expect: []string{"link.mkv", "linkdir/e.mkv", "movie.mkv", "show/d.MKV"},
+		},
+		{
+			name: "include",
+			modify: func(l *config.Location) {
+				l.Include = []string{"show/**"}
+			},
+			expect: []string{"show/d.MKV"},
+		},
+		{
+			name: "hidden files",
+			modify: func(l *config.Location) {
+				l.SkipHidden = false
+			},
+			expect: []string{".Trash/a.mkv", ".hidden.mkv", "movie.mkv", "show/d.MKV"},
+		},
+		{
+			name: "location extensions",
+			modify: func(l *config.Location) {
+				l.VideoExtensions = []string{"txt"}
+			},
+			expect: []string{"notes.txt"},
+		},
+		{
+			name: "no min size",
+			modify: func(l *config.Location) {
+				l.MinSize = 0
+			},
+			expect: []string{"movie.mkv", "show/d.MKV", "small.mkv"},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			location := base
+			if tc.modify != nil {
+				tc.modify(&location)
+			}
+			got, err := scanInput(in, newVideoFilter(location, []string{"mkv"}, nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(got.videos, tc.expect); diff != "" {
+				t.Errorf("unexpected value (-got +want)\n%s", diff)
+			}
+			if tc.leftovers != nil {
+				if diff := cmp.Diff(got.leftovers, tc.leftovers); diff != "" {
+					t.Errorf("unexpected value (-got +want)\n%s", diff)
+				}
+			}
+		})
+	}
+}
+
+func TestFindVideosUploads(t *testing.T) {
+	in := t.TempDir()
+	files := map[string]string{
+		"movie.mkv":     "\x1a\x45\xdf\xa3 video",
+		"next.mkv.part": "\x1a\x45\xdf\xa3 video",
+		"notes.txt":     "notes",
+	}
+	for f, content := range files {
+		err := os.WriteFile(filepath.Join(in, f), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var probed []string
+	md := newMediaDetector(func(file string) (ffprobe.ProbeData, error) {
+		probed = append(probed, filepath.Base(file))
+		if filepath.Ext(file) == ".txt" {
+			return ffprobe.ProbeData{}, fmt.Errorf("invalid data found when processing input")
+		}
+		return ffprobe.ProbeData{Streams: []ffprobe.Stream{{CodecType: "video"}}}, nil
+	})
+	md.newPass()
+	location := config.Location{Detect: config.DetectContent, UploadSuffixes: []string{".part"}}
+
+	// the upload is neither probed nor processed, it is a leftover until it is renamed
+	got, err := scanInput(in, newVideoFilter(location, nil, md))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got.videos, []string{"movie.mkv"}); diff != "" {
+		t.Errorf("unexpected value (-got +want)\n%s", diff)
+	}
+	if diff := cmp.Diff(got.leftovers, []string{"next.mkv.part", "notes.txt"}); diff != "" {
+		t.Errorf("unexpected value (-got +want)\n%s", diff)
+	}
+	for _, f := range probed {
+		if f == "next.mkv.part" {
+			t.Errorf("expected the upload not to be probed")
+		}
+	}
+}
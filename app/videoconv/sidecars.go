@@ -0,0 +1,117 @@
+package videoconv
+
+import (
+	"github.com/AndresBott/videoconv/app/videoconv/config"
+	"github.com/AndresBott/videoconv/internal/glob"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// siblingPattern matches the files named after a video, e.g. movie.en.srt for movie.mkv
+const siblingPattern = config.SidecarName + ".*"
+
+// extensions of the sidecar files exposed as subtitles to the templates
+var subtitleExtensions = []string{"srt", "ass", "ssa", "vtt", "sup", "idx"}
+
+// sidecarData is the data of the sidecar files available to the templates, the paths are absolute
+type sidecarData struct {
+	Files     []string
+	Subtitles []string
+}
+
+// newSidecarData returns the sidecar files of the job
+func newSidecarData(j *job) sidecarData {
+	data := sidecarData{}
+	for _, rel := range j.sidecars {
+		file := filepath.Join(j.in, rel)
+		data.Files = append(data.Files, file)
+		ext := strings.TrimPrefix(filepath.Ext(rel), ".")
+		if isVideo(ext, subtitleExtensions) {
+			data.Subtitles = append(data.Subtitles, file)
+		}
+	}
+	return data
+}
+
+// sidecarPatterns returns the sidecar patterns of a location, move-with-video adds the files named after the video
+func sidecarPatterns(location config.Location) []string {
+	if location.Leftovers == config.LeftoverMoveWithVideo {
+		return appendPattern(location.Sidecars, siblingPattern)
+	}
+	return location.Sidecars
+}
+
+// matchSidecars assigns the leftover files that match one of the sidecar patterns to the video of the same
+// directory, a file matched by several videos belongs to the one with the longest name
+func matchSidecars(found scanResult, patterns []string, uploadSuffixes []string) map[string][]string {
+	sidecars := map[string][]string{}
+	if len(patterns) == 0 {
+		return sidecars
+	}
+	byDir := map[string][]string{}
+	for _, v := range found.videos {
+		byDir[filepath.Dir(v)] = append(byDir[filepath.Dir(v)], v)
+	}
+	for _, l := range found.leftovers {
+		if isUpload(l, uploadSuffixes) {
+			continue
+		}
+		owner := ""
+		for _, v := range byDir[filepath.Dir(l)] {
+			if len(v) > len(owner) && isSidecar(v, l, patterns) {
+				owner = v
+			}
+		}
+		if owner != "" {
+			sidecars[owner] = append(sidecars[owner], l)
+		}
+	}
+	return sidecars
+}
+
+// isSidecar checks if the file name matches one of the sidecar patterns of the video
+func isSidecar(video, file string, patterns []string) bool {
+	base := filepath.Base(video)
+	name := glob.QuoteMeta(strings.TrimSuffix(base, filepath.Ext(base)))
+	for _, p := range patterns {
+		if glob.Match(strings.ReplaceAll(p, config.SidecarName, name), filepath.Base(file)) {
+			return true
+		}
+	}
+	return false
+}
+
+// appendPattern adds the pattern to the list unless it is already there
+func appendPattern(patterns []string, pattern string) []string {
+	for _, p := range patterns {
+		if p == pattern {
+			return patterns
+		}
+	}
+	return append(append([]string{}, patterns...), pattern)
+}
+
+// moveSidecars moves the sidecar files of the video into dir keeping their path relative to the input dir,
+// errors are only logged as they don't change the outcome of the video. Existing files are never replaced
+// nor kept in place of a sidecar, the sidecar gets a free name instead.
+func moveSidecars(j *job, dir string) {
+	for _, rel := range j.sidecars {
+		src := filepath.Join(j.in, rel)
+		if _, err := os.Stat(src); err != nil {
+			continue
+		}
+		step, decision, err := resolveConflict(config.ConflictRename, src, filepath.Join(dir, rel))
+		if err != nil {
+			j.log.Warnf("leaving \"%s\" in the input dir: %v", rel, err)
+			continue
+		}
+		if decision != "" {
+			j.log.Infof("%s: %s", filepath.Base(rel), decision)
+		}
+		err = publish(step)
+		if err != nil {
+			j.log.Warnf("unable to move \"%s\" along with the video: %v", rel, err)
+		}
+	}
+}
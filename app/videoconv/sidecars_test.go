@@ -0,0 +1,150 @@
+package videoconv
+
+import (
+	"github.com/AndresBott/videoconv/app/videoconv/config"
+	"github.com/google/go-cmp/cmp"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMatchSidecars(t *testing.T) {
+	found := scanResult{
+		videos: []string{"a/movie.mkv", "a/movie.cut.mkv", "b/Film [2020].mkv"},
+		leftovers: []string{
+			"a/movie.nfo", "a/movie-poster.jpg", "a/movie.cut.en.srt", "a/movie.en.srt.part", "a/notes.txt",
+			"b/Film [2020].de.srt", "b/Film 2.srt", "c/movie.nfo",
+		},
+	}
+	got := matchSidecars(found, []string{"{name}.*", "{name}-*"}, []string{".part"})
+	expect := map[string][]string{
+		"a/movie.mkv":       {"a/movie.nfo", "a/movie-poster.jpg"},
+		"a/movie.cut.mkv":   {"a/movie.cut.en.srt"},
+		"b/Film [2020].mkv": {"b/Film [2020].de.srt"},
+	}
+	if diff := cmp.Diff(got, expect); diff != "" {
+		t.Errorf("unexpected value (-got +want)\n%s", diff)
+	}
+}
+
+func TestPlanSidecars(t *testing.T) {
+	log.SetOutput(io.Discard) // discard the log entries
+	vc, tmpPath := newVideConv(t)
+	for _, f := range []string{"video.en.srt", "video.nfo"} {
+		err := os.WriteFile(filepath.Join(tmpPath, "in/nested", f), []byte("data"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	location := vc.Cfg.Locations[0]
+	location.Sidecars = []string{"{name}.*"}
+	location.Profiles = []config.Profile{
+		{Name: "subs", Template: "subtitles"},
+	}
+	vc.Cfg.Locations[0] = location
+	jobs, err := vc.locationJobs(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	planned := false
+	for _, j := range jobs {
+		if !strings.HasSuffix(j.video, "nested/video.mp4") {
+			continue
+		}
+		planned = true
+		plan, err := vc.planVideo(j)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff := cmp.Diff(plan.Sidecars, []string{"nested/video.en.srt", "nested/video.nfo"}); diff != "" {
+			t.Errorf("unexpected value (-got +want)\n%s", diff)
+		}
+		cmd := plan.Renditions[0].Cmd.String()
+		if !strings.Contains(cmd, "-i "+filepath.Join(tmpPath, "in/nested/video.en.srt")) {
+			t.Errorf("expected the subtitle as extra input, got: %s", cmd)
+		}
+		if strings.Contains(cmd, "video.nfo") {
+			t.Errorf("expected only subtitles as extra inputs, got: %s", cmd)
+		}
+	}
+	if !planned {
+		t.Errorf("expected a job for the video")
+	}
+}
+
+func TestProcessVideoSidecars(t *testing.T) {
+	log.SetOutput(io.Discard) // discard the log entries
+
+	// out already holds a file with the name of the nfo sidecar
+	tcs := []struct {
+		action string
+		expect map[string]string
+	}{
+		{
+			action: config.SourceMoveToOut,
+			expect: map[string]string{
+				"out/nested/video.1.nfo":  "sidecar",
+				"out/nested/video.en.srt": "sidecar",
+				"out/nested/video.nfo":    "existing",
+			},
+		},
+		{
+			action: config.SourceKeep,
+			expect: map[string]string{
+				"in/nested/video.en.srt": "sidecar",
+				"in/nested/video.nfo":    "sidecar",
+				"out/nested/video.nfo":   "existing",
+			},
+		},
+		{
+			action: config.SourceHardlink,
+			expect: map[string]string{
+				"in/nested/video.en.srt": "sidecar",
+				"in/nested/video.nfo":    "sidecar",
+				"out/nested/video.nfo":   "existing",
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.action, func(t *testing.T) {
+			vc, tmpPath := newVideConv(t)
+			files := map[string]string{
+				"in/nested/video.en.srt": "sidecar",
+				"in/nested/video.nfo":    "sidecar",
+				"out/nested/video.nfo":   "existing",
+			}
+			for f, content := range files {
+				err := os.MkdirAll(filepath.Join(tmpPath, filepath.Dir(f)), 0755)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = os.WriteFile(filepath.Join(tmpPath, f), []byte(content), 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			_, got := runJob(t, vc, tmpPath, "echo done > \"$last\"", func(location *config.Location) {
+				location.Sidecars = []string{"{name}.*"}
+				// the policy for the renditions does not apply to the sidecars
+				location.OnConflict = config.ConflictSkip
+				location.SourceAction = tc.action
+				location.ArchiveDir = "archive"
+			}, "in/nested", "out/nested")
+			// only the sidecars are of interest
+			for f := range got {
+				if strings.HasSuffix(f, ".mp4") {
+					delete(got, f)
+				}
+			}
+			if diff := cmp.Diff(got, tc.expect); diff != "" {
+				t.Errorf("unexpected value (-got +want)\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package videoconv
+
+import (
+	"github.com/AndresBott/videoconv/app/videoconv/config"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// sourceTarget returns the path the source video is moved or linked to once all renditions are published,
+// empty if the source stays in the input directory or is deleted
+func sourceTarget(j *job, relativePath string) string {
+	switch j.location.SourceAction {
+	case config.SourceArchive, config.SourceHardlink:
+		return filepath.Join(j.archive, relativePath)
+	case config.SourceTrash:
+		return filepath.Join(j.trash, relativePath)
+	case config.SourceDelete, config.SourceKeep:
+		return ""
+	default:
+		return filepath.Join(j.out, relativePath)
+	}
+}
+
+// keepsSource checks if the source video stays in the input directory after success
+func keepsSource(location config.Location) bool {
+	return location.SourceAction == config.SourceKeep || location.SourceAction == config.SourceHardlink
+}
+
+// resolveSource decides what happens with the source video as per source action of the location,
+// conflicts with existing files are resolved with the on_conflict policy. If the existing file is kept
+// the source stays in the input directory, an empty step is returned.
+func resolveSource(j *job, plan VideoPlan) (publishStep, error) {
+	switch j.location.SourceAction {
+	case config.SourceKeep:
+		return publishStep{}, nil
+	case config.SourceDelete:
+		return publishStep{src: j.video, discard: true}, nil
+	}
+
+	// nothing in the trash is worth failing a video for
+	policy := j.location.OnConflict
+	if j.location.SourceAction == config.SourceTrash {
+		policy = config.ConflictRename
+	}
+
+	step, decision, err := resolveConflict(policy, j.video, plan.SourceOut)
+	if err != nil {
+		return step, err
+	}
+	if step.discard {
+		// the existing file can be another video with the same name, the source is never deleted for it
+		j.log.Infof("%s: %s, the source stays in the input directory", filepath.Base(plan.SourceOut), decision)
+		return publishStep{}, nil
+	}
+	if decision != "" {
+		j.log.Infof("%s: %s", filepath.Base(plan.SourceOut), decision)
+	}
+
+	if j.location.SourceAction == config.SourceHardlink {
+		step.link = true
+	}
+	return step, nil
+}
+
+// pruneTrash deletes the videos in the trash dir of the location that are older than the retention time
+func (vc *Converter) pruneTrash(location config.Location) {
+	if location.SourceAction != config.SourceTrash {
+		return
+	}
+	locationPath, err := vc.locationPath(location)
+	if err != nil {
+		return
+	}
+	trashDir := filepath.Join(locationPath, location.TrashDir)
+	if _, err := os.Stat(trashDir); err != nil {
+		return
+	}
+
+	deadline := time.Now().Add(-location.TrashRetention)
+	err = filepath.Walk(trashDir, func(fPath string, fInfo os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fInfo.IsDir() || fInfo.ModTime().After(deadline) {
+			return nil
+		}
+		log.Infof("deleting \"%s\" from the trash", fPath)
+		e := os.Remove(fPath)
+		if e != nil {
+			log.Warnf("unable to delete file from the trash: %v", e)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Warnf("unable to prune the trash of location \"%s\": %v", location.Path, err)
+	}
+}
@@ -0,0 +1,179 @@
+package videoconv
+
+import (
+	"github.com/AndresBott/videoconv/app/videoconv/config"
+	"github.com/google/go-cmp/cmp"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSourceAction(t *testing.T) {
+	log.SetOutput(io.Discard) // discard the log entries
+
+	tcs := []struct {
+		action string
+		expect []string
+		// the source stays in the input dir and is not processed again
+		kept bool
+	}{
+		{
+			action: config.SourceMoveToOut,
+			expect: []string{"out/nested/video.mp4", "out/nested/video.test.mp4"},
+		},
+		{
+			action: config.SourceArchive,
+			expect: []string{"archive/nested/video.mp4", "out/nested/video.test.mp4"},
+		},
+		{
+			action: config.SourceHardlink,
+			expect: []string{"archive/nested/video.mp4", "in/nested/video.mp4", "out/nested/video.test.mp4"},
+			kept:   true,
+		},
+		{
+			action: config.SourceDelete,
+			expect: []string{"out/nested/video.test.mp4"},
+		},
+		{
+			action: config.SourceKeep,
+			expect: []string{"in/nested/video.mp4", "out/nested/video.test.mp4"},
+			kept:   true,
+		},
+		{
+			action: config.SourceTrash,
+			expect: []string{"out/nested/video.test.mp4", "trash/nested/video.mp4"},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.action, func(t *testing.T) {
+			vc, tmpPath := newVideConv(t)
+			edit := func(location *config.Location) {
+				location.SourceAction = tc.action
+				location.ArchiveDir = "archive"
+				location.TrashDir = "trash"
+			}
+			got, files := runJob(t, vc, tmpPath, "echo done > \"$last\"", edit, "archive", "in/nested", "out", "trash")
+			if got.Outcome != OutcomeDone {
+				t.Fatalf("unexpected result: %+v", got)
+			}
+			if diff := cmp.Diff(fileNames(files), tc.expect); diff != "" {
+				t.Errorf("unexpected value (-got +want)\n%s", diff)
+			}
+
+			if tc.kept {
+				got, _ = runJob(t, vc, tmpPath, "echo done > \"$last\"", edit)
+				if got.Outcome != OutcomeSkipped {
+					t.Errorf("expected the kept video to be skipped, got: %+v", got)
+				}
+			}
+		})
+	}
+}
+
+func TestSourceActionConflict(t *testing.T) {
+	log.SetOutput(io.Discard) // discard the log entries
+
+	// the archive already holds a smaller file with the same name as the source
+	tcs := []struct {
+		policy string
+		expect map[string]string
+	}{
+		{
+			policy: config.ConflictSkip,
+			expect: map[string]string{
+				"archive/nested/video.mp4":  "old",
+				"in/nested/video.mp4":       "<source>",
+				"out/nested/video.test.mp4": "done\n",
+			},
+		},
+		{
+			policy: config.ConflictKeepSmaller,
+			expect: map[string]string{
+				"archive/nested/video.mp4":  "old",
+				"in/nested/video.mp4":       "<source>",
+				"out/nested/video.test.mp4": "done\n",
+			},
+		},
+		{
+			policy: config.ConflictKeepLarger,
+			expect: map[string]string{
+				"archive/nested/video.mp4":  "<source>",
+				"out/nested/video.test.mp4": "done\n",
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.policy, func(t *testing.T) {
+			vc, tmpPath := newVideConv(t)
+			err := os.MkdirAll(filepath.Join(tmpPath, "archive/nested"), 0755)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = os.WriteFile(filepath.Join(tmpPath, "archive/nested/video.mp4"), []byte("old"), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			edit := func(location *config.Location) {
+				location.SourceAction = config.SourceArchive
+				location.ArchiveDir = "archive"
+				location.OnConflict = tc.policy
+			}
+			got, files := runJob(t, vc, tmpPath, "echo done > \"$last\"", edit, "archive", "in/nested", "out")
+			if got.Outcome != OutcomeDone {
+				t.Fatalf("unexpected result: %+v", got)
+			}
+			if diff := cmp.Diff(files, tc.expect); diff != "" {
+				t.Errorf("unexpected value (-got +want)\n%s", diff)
+			}
+
+			// a source left in the input dir is done and not processed again
+			if _, ok := tc.expect["in/nested/video.mp4"]; ok {
+				got, _ = runJob(t, vc, tmpPath, "echo done > \"$last\"", edit)
+				if got.Outcome != OutcomeSkipped {
+					t.Errorf("expected the kept video to be skipped, got: %+v", got)
+				}
+			}
+		})
+	}
+}
+
+func TestPruneTrash(t *testing.T) {
+	log.SetOutput(io.Discard) // discard the log entries
+	vc, tmpPath := newVideConv(t)
+	vc.Cfg.Locations[0].SourceAction = config.SourceTrash
+	vc.Cfg.Locations[0].TrashDir = "trash"
+	vc.Cfg.Locations[0].TrashRetention = time.Hour
+
+	old := filepath.Join(tmpPath, "trash/nested/old.mp4")
+	recent := filepath.Join(tmpPath, "trash/recent.mp4")
+	for _, f := range []string{old, recent} {
+		err := os.MkdirAll(filepath.Dir(f), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(f, []byte("content"), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	past := time.Now().Add(-2 * time.Hour)
+	err := os.Chtimes(old, past, past)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vc.pruneTrash(vc.Cfg.Locations[0])
+
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("expected the old file to be deleted")
+	}
+	if _, err := os.Stat(recent); err != nil {
+		t.Errorf("expected the recent file to be kept: %v", err)
+	}
+}
@@ -0,0 +1,105 @@
+package videoconv
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// fileState is the last observed state of a file in the input directory
+type fileState struct {
+	size        int64
+	modTime     time.Time
+	stableSince time.Time
+	pass        uint64
+}
+
+// stabilityTracker decides if a file is done being copied into the input directory,
+// a file is stable once its size and mtime have not changed for the settle time of the location
+type stabilityTracker struct {
+	mu      sync.Mutex
+	files   map[string]*fileState
+	pass    uint64
+	recheck *recheck
+	now     func() time.Time
+}
+
+// newStabilityTracker creates a tracker, the time until a waiting file could be ready is reported to rc
+func newStabilityTracker(rc *recheck) *stabilityTracker {
+	return &stabilityTracker{
+		files:   map[string]*fileState{},
+		recheck: rc,
+		now:     time.Now,
+	}
+}
+
+// newPass is called before searching the input directories, files not seen
+// during the previous pass are forgotten
+func (st *stabilityTracker) newPass() {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	for k, f := range st.files {
+		if f.pass < st.pass {
+			delete(st.files, k)
+		}
+	}
+	st.pass++
+}
+
+// ready checks if the file is stable, if not the reason is returned
+func (st *stabilityTracker) ready(file string, settle time.Duration, uploadSuffixes []string, exclusive bool) (bool, string) {
+	for _, suffix := range uploadSuffixes {
+		if _, err := os.Stat(file + suffix); err == nil {
+			return false, fmt.Sprintf("upload file \"%s\" still exists", file+suffix)
+		}
+	}
+
+	if settle > 0 {
+		fInfo, err := os.Stat(file)
+		if err != nil {
+			return false, err.Error()
+		}
+
+		st.mu.Lock()
+		now := st.now()
+		state, ok := st.files[file]
+		if !ok {
+			// files we see for the first time are considered stable since their last modification
+			state = &fileState{
+				size:        fInfo.Size(),
+				modTime:     fInfo.ModTime(),
+				stableSince: fInfo.ModTime(),
+			}
+			st.files[file] = state
+		} else if state.size != fInfo.Size() || !state.modTime.Equal(fInfo.ModTime()) {
+			state.size = fInfo.Size()
+			state.modTime = fInfo.ModTime()
+			state.stableSince = now
+		}
+		state.pass = st.pass
+
+		if state.stableSince.After(now) {
+			// mtime in the future, wait until it was not changed for the settle time from now on
+			state.stableSince = now
+		}
+		remaining := settle - now.Sub(state.stableSince)
+		if remaining > 0 {
+			st.recheck.after(remaining)
+			st.mu.Unlock()
+			return false, fmt.Sprintf("file changed recently, waiting %s to settle", remaining.Round(time.Second))
+		}
+		st.mu.Unlock()
+	}
+
+	if exclusive {
+		locked, err := isLocked(file)
+		if err != nil {
+			return false, err.Error()
+		}
+		if locked {
+			return false, "file is in use by another process"
+		}
+	}
+	return true, ""
+}
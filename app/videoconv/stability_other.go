@@ -0,0 +1,8 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd && !dragonfly
+
+package videoconv
+
+// isLocked is not supported on this platform, files are never considered locked
+func isLocked(file string) (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,91 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package videoconv
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestStabilityTracker(t *testing.T) {
+	tmpDir := t.TempDir()
+	video := filepath.Join(tmpDir, "video.mkv")
+	err := os.WriteFile(video, []byte("content"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	err = os.Chtimes(video, old, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	rc := &recheck{}
+	st := newStabilityTracker(rc)
+	st.now = func() time.Time { return now }
+	settle := time.Minute
+
+	// not modified for an hour, ready on the first pass
+	st.newPass()
+	if ok, reason := st.ready(video, settle, nil, false); !ok {
+		t.Errorf("expected video to be ready, got: %s", reason)
+	}
+
+	// the file keeps growing while the mtime is preserved by the copy tool
+	err = os.WriteFile(video, []byte("more content"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chtimes(video, old, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st.newPass()
+	if ok, _ := st.ready(video, settle, nil, false); ok {
+		t.Errorf("expected video to wait after a size change")
+	}
+	if rc.next() != settle {
+		t.Errorf("expected next check in %s, got: %s", settle, rc.next())
+	}
+
+	// unchanged for the settle time
+	now = now.Add(settle)
+	st.newPass()
+	if ok, reason := st.ready(video, settle, nil, false); !ok {
+		t.Errorf("expected video to be ready after settling, got: %s", reason)
+	}
+
+	// upload suffix
+	err = os.WriteFile(video+".part", []byte(""), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := st.ready(video, 0, []string{".part"}, false); ok {
+		t.Errorf("expected video to wait while the .part file exists")
+	}
+	_ = os.Remove(video + ".part")
+
+	// locked by another process
+	f, err := os.Open(video)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := st.ready(video, 0, nil, true); ok {
+		t.Errorf("expected locked video to wait")
+	}
+	_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+	if ok, reason := st.ready(video, 0, nil, true); !ok {
+		t.Errorf("expected unlocked video to be ready, got: %s", reason)
+	}
+}
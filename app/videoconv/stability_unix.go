@@ -0,0 +1,43 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package videoconv
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// isLocked checks if another process holds a lock on the file, both flock and posix (fcntl) locks
+// are checked, e.g. samba uses the latter while a client is writing
+func isLocked(file string) (bool, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return false, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	fd := int(f.Fd())
+
+	err = syscall.Flock(fd, syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return true, nil
+		}
+		return false, err
+	}
+	_ = syscall.Flock(fd, syscall.LOCK_UN)
+
+	lk := syscall.Flock_t{
+		Type:   syscall.F_WRLCK,
+		Whence: 0,
+		Start:  0,
+		Len:    0,
+	}
+	err = syscall.FcntlFlock(f.Fd(), syscall.F_GETLK, &lk)
+	if err != nil {
+		return false, err
+	}
+	return lk.Type != syscall.F_UNLCK, nil
+}
@@ -1,13 +1,20 @@
 package videoconv
 
 import (
+	"bytes"
+	"context"
 	"github.com/AndresBott/videoconv/app/videoconv/config"
+	"github.com/AndresBott/videoconv/internal/ffmpegtranscode"
 	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
 	log "github.com/sirupsen/logrus"
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 	"testing"
+	"time"
 )
 
 func newVideConv(t *testing.T) (*Converter, string) {
@@ -48,6 +55,15 @@ func newVideConv(t *testing.T) (*Converter, string) {
 		"mkv":          `{"args":[],"extension":"mkv"}`,
 		"broken-param": `{"args":["-a"]}`,
 		"extension":    `{"extension":"{{ .Profile.Extension }}"}`,
+		"subtitles":    `{"args":[{{ range .Sidecars.Subtitles }}"-i","{{ . }}",{{ end }}"-c","copy"]}`,
+		"hls":          `{"args":["-f","hls"],"output_mode":"directory","output_file":"index.m3u8"}`,
+		"hwaccel":      `{"init":["-hwaccel","auto"],"args":[]}`,
+		"passout":      `{"passes":[{"args":[],"output":"/tmp/elsewhere.mkv"}]}`,
+		"passinit":     `{"init":["{{ if eq .Pass 2 }}{{ .Measurements.device }}{{ end }}"],"passes":[{"args":["-f","null"],"output":"-"},{"args":[]}]}`,
+		"twopass": `{"passes":[
+			{"args":["-af","loudnorm=print_format=json","-passlogfile","{{ .PassLogFile }}","-pass","1","-f","null"],"output":"-"},
+			{"args":["-af","loudnorm=measured_I={{ .Measurements.input_i }}","-passlogfile","{{ .PassLogFile }}","-pass","2"]}
+		]}`,
 	}
 
 	for k, v := range templates {
@@ -86,6 +102,104 @@ func newVideConv(t *testing.T) (*Converter, string) {
 	return vc, location
 }
 
+// fakeFfmpeg replaces the ffmpeg binary of the converter with a shell script,
+// the variable $last contains the output file
+func fakeFfmpeg(t *testing.T, vc *Converter, script string) {
+	bin := filepath.Join(t.TempDir(), "ffmpeg")
+	content := "#!/bin/sh\nfor last; do true; done\n" + script + "\n"
+	err := os.WriteFile(bin, []byte(content), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vc.ffmpeg, err = ffmpegtranscode.New(ffmpegtranscode.Cfg{FfmpegBin: bin})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// runJob processes nested/video.mp4 with a fake ffmpeg running script, after edit changed the
+// location, which has the profile "test" with the empty template by default. It returns the result
+// and the files below dirs with their content, "<source>" for the source video and "<report>" for
+// error reports, the journal is left out.
+func runJob(t *testing.T, vc *Converter, tmpPath, script string, edit func(location *config.Location), dirs ...string) (VideoResult, map[string]string) {
+	t.Helper()
+	fakeFfmpeg(t, vc, script)
+	source, err := os.ReadFile(filepath.Join(tmpPath, "in/nested/video.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	location := &vc.Cfg.Locations[0]
+	location.Profiles = []config.Profile{
+		{Name: "test", Template: "empty"},
+	}
+	if edit != nil {
+		edit(location)
+	}
+	// create the directories of the location, like the archive dir
+	err = vc.Check(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs, err := vc.locationJobs(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result *VideoResult
+	for _, j := range jobs {
+		if strings.HasSuffix(j.video, "nested/video.mp4") {
+			res := vc.processVideo(context.Background(), j)
+			result = &res
+		}
+	}
+	if result == nil {
+		t.Fatal("no job for nested/video.mp4")
+	}
+
+	files := map[string]string{}
+	for _, dir := range dirs {
+		err = filepath.Walk(filepath.Join(tmpPath, dir), func(fPath string, fInfo os.FileInfo, err error) error {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			if err != nil || fInfo.IsDir() {
+				return err
+			}
+			rel, _ := filepath.Rel(tmpPath, fPath)
+			if filepath.Base(filepath.Dir(rel)) == journalDir {
+				return nil
+			}
+			b, err := os.ReadFile(fPath)
+			if err != nil {
+				return err
+			}
+			switch {
+			case string(b) == string(source):
+				files[rel] = "<source>"
+			case strings.HasSuffix(rel, ".videoconv-error.json"):
+				files[rel] = "<report>"
+			default:
+				files[rel] = string(b)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return *result, files
+}
+
+// fileNames returns the sorted names of the files returned by runJob
+func fileNames(files map[string]string) []string {
+	var names []string
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 func TestCheck(t *testing.T) {
 	log.SetOutput(io.Discard) // discard the log entries
 	vc, locationDir := newVideConv(t)
@@ -162,7 +276,7 @@ func TestRunLocation(t *testing.T) {
 		got.Fail = absFail
 	}
 
-	vc.runLocation(vc.Cfg.Locations[0])
+	vc.runLocation(0)
 
 	if diff := cmp.Diff(got, want); diff != "" {
 		t.Errorf("unexpected value (-got +want)\n%s", diff)
@@ -212,7 +326,11 @@ func TestProcessVideo(t *testing.T) {
 			},
 			prepare: func(path string, t *testing.T) {
 				// generate a tmp file simulating a leftover
-				err := os.WriteFile(filepath.Join(path, "tmp/video.test.mp4"), []byte("content"), 0644)
+				err := os.MkdirAll(filepath.Join(path, "tmp/nested"), 0755)
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				err = os.WriteFile(filepath.Join(path, "tmp/nested/video.test.mp4"), []byte("content"), 0644)
 				if err != nil {
 					t.Fatalf("unexpected error: %v", err)
 				}
@@ -267,12 +385,12 @@ func TestProcessVideo(t *testing.T) {
 				tc.prepare(tmpPath, t)
 			}
 
-			inPath := filepath.Join(tmpPath, "in")
-			outPath := filepath.Join(tmpPath, "out")
-			tmp := filepath.Join(tmpPath, "tmp")
-			fail := filepath.Join(tmpPath, "fail")
+			j := newJob(location, tmpPath, "nested/video.mp4")
+			if j.video != videoPath {
+				t.Fatalf("unexpected job video path: %s", j.video)
+			}
 
-			vc.processVideo(videoPath, inPath, outPath, tmp, fail, location.Profiles)
+			vc.processVideo(context.Background(), j)
 
 			files := []string{}
 			err := filepath.Walk(tmpPath, func(fPath string, fInfo os.FileInfo, err error) error {
@@ -311,3 +429,404 @@ func TestProcessVideo(t *testing.T) {
 	}
 
 }
+
+func TestPlan(t *testing.T) {
+	log.SetOutput(io.Discard) // discard the log entries
+	vc, tmpPath := newVideConv(t)
+	vc.Cfg.Locations[0].Profiles = []config.Profile{
+		{
+			Name:     "test",
+			Template: "mkv",
+		},
+	}
+
+	plans := vc.Plan()
+
+	want := []VideoPlan{
+		{
+			Location: "sample",
+			Video:    filepath.Join(tmpPath, "in/nested/video.mp4"),
+			Renditions: []RenditionPlan{
+				{
+					Profile:  "test",
+					Template: filepath.Join(filepath.Dir(tmpPath), "templates/mkv.tmpl.json"),
+					Cmd: ffmpegtranscode.CmdArgs{
+						Ffmpeg: config.DefaultFFmpeg,
+						Input:  filepath.Join(tmpPath, "in/nested/video.mp4"),
+						Output: filepath.Join(tmpPath, "tmp/nested/video.test.mkv"),
+					},
+					TmpFile: filepath.Join(tmpPath, "tmp/nested/video.test.mkv"),
+					OutFile: filepath.Join(tmpPath, "out/nested/video.test.mkv"),
+				},
+			},
+			SourceOut: filepath.Join(tmpPath, "out/nested/video.mp4"),
+		},
+		{
+			Location:  "sample",
+			Video:     filepath.Join(tmpPath, "in/video1.MKV"),
+			SourceOut: filepath.Join(tmpPath, "out/video1.MKV"),
+			Error:     "unable to run ffprobe on video: error running ffprobe command: exit status 1",
+		},
+	}
+	if diff := cmp.Diff(plans, want, cmpopts.IgnoreUnexported(VideoPlan{}, RenditionPlan{})); diff != "" {
+		t.Errorf("unexpected value (-got +want)\n%s", diff)
+	}
+
+	// the plan must not touch the filesystem
+	if _, err := os.Stat(filepath.Join(tmpPath, "tmp/nested")); !os.IsNotExist(err) {
+		t.Errorf("expected tmp directory to not be created")
+	}
+}
+
+func TestProcessVideoInterrupted(t *testing.T) {
+	log.SetOutput(io.Discard) // discard the log entries
+	vc, tmpPath := newVideConv(t)
+
+	// fake ffmpeg that writes a partial output and never finishes on its own
+	fakeFfmpeg(t, vc, "echo partial > \"$last\"\nexec sleep 30")
+
+	location := vc.Cfg.Locations[0]
+	location.Profiles = []config.Profile{
+		{
+			Name:     "test",
+			Template: "empty",
+		},
+	}
+	j := newJob(location, tmpPath, "nested/video.mp4")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	vc.processVideo(ctx, j)
+
+	// the source stays in place and the partial tmp output is removed
+	if _, err := os.Stat(filepath.Join(tmpPath, "in/nested/video.mp4")); err != nil {
+		t.Errorf("expected source video to stay in the input dir: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpPath, "tmp/nested/video.test.mp4")); !os.IsNotExist(err) {
+		t.Errorf("expected partial tmp file to be deleted")
+	}
+	if _, err := os.Stat(filepath.Join(tmpPath, "fail/nested/video.mp4")); !os.IsNotExist(err) {
+		t.Errorf("expected video not to be moved to the fail dir")
+	}
+}
+
+func TestProcessVideoWhen(t *testing.T) {
+	log.SetOutput(io.Discard) // discard the log entries
+	vc, tmpPath := newVideConv(t)
+
+	profiles := []config.Profile{
+		{Name: "small", Template: "empty", When: "height <= 480 && File.Ext == \"mp4\""},
+		{Name: "hd", Template: "empty", When: "Video.Summary.Video.H >= 1080"},
+		{Name: "reencode", Template: "empty", When: "codec != \"h264\""},
+		{Name: "typo", Template: "empty", When: "heigth <= 480"},
+		{Name: "always", Template: "empty"},
+	}
+	location := vc.Cfg.Locations[0]
+	location.Profiles = profiles
+	plan, err := vc.planVideo(newJob(location, tmpPath, "nested/video.mp4"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(plan.Skipped, []string{"hd", "reencode", "typo"}); diff != "" {
+		t.Errorf("unexpected value (-got +want)\n%s", diff)
+	}
+
+	_, files := runJob(t, vc, tmpPath, "echo done > \"$last\"", func(location *config.Location) {
+		location.Profiles = profiles
+	}, "out")
+	want := []string{
+		"out/nested/video.always.mp4",
+		"out/nested/video.mp4",
+		"out/nested/video.small.mp4",
+	}
+	if diff := cmp.Diff(fileNames(files), want); diff != "" {
+		t.Errorf("unexpected value (-got +want)\n%s", diff)
+	}
+}
+
+func TestProcessVideoDirectoryOutput(t *testing.T) {
+	log.SetOutput(io.Discard) // discard the log entries
+
+	tcs := []struct {
+		name    string
+		script  string
+		outcome string
+		expect  []string
+	}{
+		{
+			name:    "published",
+			script:  "echo playlist > \"$last\"; echo segment > \"$(dirname \"$last\")/seg-0.ts\"",
+			outcome: OutcomeDone,
+			expect:  []string{"out/nested/video.hls/index.m3u8", "out/nested/video.hls/seg-0.ts", "out/nested/video.mp4"},
+		},
+		{
+			name:    "failed",
+			script:  "echo segment > \"$(dirname \"$last\")/seg-0.ts\"; exit 1",
+			outcome: OutcomeFailed,
+			expect:  []string{"fail/nested/video.mp4", "fail/nested/video.mp4.videoconv-error.json"},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			vc, tmpPath := newVideConv(t)
+			vc.Cfg.LogLevel = "info"
+			got, files := runJob(t, vc, tmpPath, tc.script, func(location *config.Location) {
+				location.Profiles = []config.Profile{
+					{Name: "hls", Template: "hls"},
+				}
+			}, "fail", "out", "tmp")
+			if got.Outcome != tc.outcome {
+				t.Fatalf("unexpected result: %+v", got)
+			}
+			if diff := cmp.Diff(fileNames(files), tc.expect); diff != "" {
+				t.Errorf("unexpected value (-got +want)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestProcessVideoSingleDecode(t *testing.T) {
+	log.SetOutput(io.Discard) // discard the log entries
+
+	tcs := []struct {
+		name         string
+		singleDecode bool
+		expectRuns   int
+	}{
+		{
+			name:       "sequential",
+			expectRuns: 4,
+		},
+		{
+			// the hwaccel profile has different init args and the subtitles profile adds inputs,
+			// both run on their own
+			name:         "single decode",
+			singleDecode: true,
+			expectRuns:   3,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			vc, tmpPath := newVideConv(t)
+			vc.Cfg.LogLevel = "info"
+			err := os.WriteFile(filepath.Join(tmpPath, "in/nested/video.en.srt"), []byte("subtitle"), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// count the runs and write every output in the tmp dir
+			runsFile := filepath.Join(t.TempDir(), "runs")
+			script := "echo run >> \"" + runsFile + "\"; for a; do case \"$a\" in */tmp/*) echo done > \"$a\";; esac; done"
+			got, files := runJob(t, vc, tmpPath, script, func(location *config.Location) {
+				location.SingleDecode = tc.singleDecode
+				location.Sidecars = []string{"{name}.*"}
+				location.Profiles = []config.Profile{
+					{Name: "a", Template: "empty"},
+					{Name: "b", Template: "hwaccel"},
+					{Name: "c", Template: "mkv"},
+					{Name: "d", Template: "subtitles"},
+				}
+			}, "out")
+			if got.Outcome != OutcomeDone {
+				t.Fatalf("unexpected result: %+v", got)
+			}
+
+			runs, err := os.ReadFile(runsFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := strings.Count(string(runs), "run"); n != tc.expectRuns {
+				t.Errorf("expected %d ffmpeg runs, got %d", tc.expectRuns, n)
+			}
+
+			expect := []string{"out/nested/video.a.mp4", "out/nested/video.b.mp4", "out/nested/video.c.mkv", "out/nested/video.d.mp4", "out/nested/video.en.srt", "out/nested/video.mp4"}
+			if diff := cmp.Diff(fileNames(files), expect); diff != "" {
+				t.Errorf("unexpected value (-got +want)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestProcessVideoPasses(t *testing.T) {
+	log.SetOutput(io.Discard) // discard the log entries
+
+	tcs := []struct {
+		name    string
+		script  string
+		outcome string
+		err     string
+		expect  []string
+	}{
+		{
+			name:    "success",
+			script:  "[ \"$last\" = - ] && printf '[Parsed_loudnorm_0]\\n{\\n\\t\"input_i\" : \"-27.61\"\\n}\\n' >&2 || echo done > \"$last\"",
+			outcome: OutcomeDone,
+			expect: []string{
+				"-af loudnorm=print_format=json -passlogfile PASSLOG -pass 1 -f null -",
+				"-af loudnorm=measured_I=-27.61 -passlogfile PASSLOG -pass 2 TMPFILE",
+			},
+		},
+		{
+			name:    "second pass fails",
+			script:  "[ \"$last\" = - ] && printf '{\"input_i\" : \"-27.61\"}' >&2 || exit 1",
+			outcome: OutcomeFailed,
+			expect: []string{
+				"-af loudnorm=print_format=json -passlogfile PASSLOG -pass 1 -f null -",
+				"-af loudnorm=measured_I=-27.61 -passlogfile PASSLOG -pass 2 TMPFILE",
+			},
+		},
+		{
+			// the second pass does not run without the measurement
+			name:    "missing measurement",
+			script:  "[ \"$last\" = - ] || echo done > \"$last\"",
+			outcome: OutcomeFailed,
+			err:     "pass 2 uses a value that is missing",
+			expect: []string{
+				"-af loudnorm=print_format=json -passlogfile PASSLOG -pass 1 -f null -",
+			},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			vc, tmpPath := newVideConv(t)
+			vc.Cfg.LogLevel = "info"
+			// record the output args of every run and write a pass log file like x264 does
+			runsFile := filepath.Join(t.TempDir(), "runs")
+			script := "while [ \"$1\" != -i ]; do shift; done; shift 2; echo \"$@\" >> \"" + runsFile + "\"\n" +
+				"prev=; for a; do [ \"$prev\" = -passlogfile ] && touch \"$a-0.log\"; prev=$a; done\n" + tc.script
+			got, files := runJob(t, vc, tmpPath, script, func(location *config.Location) {
+				location.SingleDecode = true
+				location.Profiles = []config.Profile{
+					{Name: "norm", Template: "twopass"},
+				}
+			}, "tmp")
+			if got.Outcome != tc.outcome {
+				t.Fatalf("unexpected result: %+v", got)
+			}
+			if !strings.Contains(got.Error, tc.err) {
+				t.Errorf("expected the error to contain \"%s\", got: %s", tc.err, got.Error)
+			}
+
+			runs, err := os.ReadFile(runsFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			replacer := strings.NewReplacer(
+				filepath.Join(tmpPath, "tmp/nested/video.norm.passlog"), "PASSLOG",
+				filepath.Join(tmpPath, "tmp/nested/video.norm.mp4"), "TMPFILE",
+			)
+			lines := strings.Split(strings.TrimSpace(replacer.Replace(string(runs))), "\n")
+			if diff := cmp.Diff(lines, tc.expect); diff != "" {
+				t.Errorf("unexpected value (-got +want)\n%s", diff)
+			}
+
+			// the pass log files are deleted
+			for f := range files {
+				if strings.Contains(f, ".passlog") {
+					t.Errorf("expected the pass log files to be deleted, found: %s", f)
+				}
+			}
+		})
+	}
+}
+
+func TestProcessVideoPassesInit(t *testing.T) {
+	log.SetOutput(io.Discard) // discard the log entries
+
+	tcs := []struct {
+		name    string
+		script  string
+		outcome string
+		err     string
+	}{
+		{
+			// the init args of the second pass render empty and are dropped
+			name:    "empty init arg",
+			script:  "[ \"$last\" = - ] && printf '{\"device\" : \"\"}' >&2 || echo done > \"$last\"",
+			outcome: OutcomeDone,
+		},
+		{
+			name:    "missing measurement in init",
+			script:  "[ \"$last\" = - ] || echo done > \"$last\"",
+			outcome: OutcomeFailed,
+			err:     "pass 2 uses a value that is missing",
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			vc, tmpPath := newVideConv(t)
+			vc.Cfg.LogLevel = "info"
+			// record every empty argument ffmpeg is called with
+			runsFile := filepath.Join(t.TempDir(), "runs")
+			script := "echo run >> \"" + runsFile + "\"; for a; do [ -z \"$a\" ] && echo empty >> \"" + runsFile + "\"; done\n" + tc.script
+			got, _ := runJob(t, vc, tmpPath, script, func(location *config.Location) {
+				location.Profiles = []config.Profile{
+					{Name: "norm", Template: "passinit"},
+				}
+			})
+			if got.Outcome != tc.outcome {
+				t.Fatalf("unexpected result: %+v", got)
+			}
+			if !strings.Contains(got.Error, tc.err) {
+				t.Errorf("expected the error to contain \"%s\", got: %s", tc.err, got.Error)
+			}
+			runs, err := os.ReadFile(runsFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Contains(string(runs), "empty") {
+				t.Errorf("expected no empty arguments, got:\n%s", runs)
+			}
+		})
+	}
+}
+
+func TestProcessVideoProgress(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(io.Discard)
+	interval := progressLogInterval
+	progressLogInterval = 0
+	defer func() { progressLogInterval = interval }()
+
+	vc, tmpPath := newVideConv(t)
+	vc.Cfg.LogLevel = "info"
+	// the test video is 2.262s long
+	fakeFfmpeg(t, vc, "printf 'fps=25.00\\nout_time_us=1131000\\nspeed=0.5x\\nprogress=continue\\n' >&3\n"+
+		"printf 'out_time_us=2262000\\nspeed=0.5x\\nprogress=end\\n' >&3\necho done > \"$last\"")
+
+	location := vc.Cfg.Locations[0]
+	location.Profiles = []config.Profile{
+		{Name: "test", Template: "empty"},
+	}
+	got := vc.processVideo(context.Background(), newJob(location, tmpPath, "nested/video.mp4"))
+	if got.Outcome != OutcomeDone {
+		t.Fatalf("unexpected result: %+v", got)
+	}
+
+	expect := `profile \"test\": 50.0% (1s) at 0.50x, 25 fps, eta 2s`
+	if !strings.Contains(buf.String(), expect) {
+		t.Errorf("expected the log to contain %s, got:\n%s", expect, buf.String())
+	}
+	// the end of the run is not logged as progress
+	if strings.Count(buf.String(), "% (") != 1 {
+		t.Errorf("expected a single progress entry, got:\n%s", buf.String())
+	}
+}
+
+func TestPlanPassOutput(t *testing.T) {
+	log.SetOutput(io.Discard) // discard the log entries
+	vc, tmpPath := newVideConv(t)
+
+	location := vc.Cfg.Locations[0]
+	location.Profiles = []config.Profile{
+		{Name: "out", Template: "passout"},
+	}
+	_, err := vc.planVideo(newJob(location, tmpPath, "nested/video.mp4"))
+	if err == nil || !strings.Contains(err.Error(), "can only be \"-\" or empty") {
+		t.Errorf("expected an error for a pass writing outside of the tmp dir, got: %v", err)
+	}
+}
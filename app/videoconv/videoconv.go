@@ -1,11 +1,14 @@
 package videoconv
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"github.com/AndresBott/videoconv/app/videoconv/config"
 	"github.com/AndresBott/videoconv/internal/ffmpegtranscode"
 	"github.com/AndresBott/videoconv/internal/ffprobe"
-	"github.com/AndresBott/videoconv/internal/tmpl"
+	"github.com/AndresBott/videoconv/internal/fsutil"
+	"github.com/AndresBott/videoconv/internal/resources"
 	log "github.com/sirupsen/logrus"
 	"os"
 	"path/filepath"
@@ -16,8 +19,14 @@ import (
 type Converter struct {
 	Cfg        config.Conf
 	DaemonMode bool
-	ffmpeg     *ffmpegtranscode.Transcoder
-	ffprobe    ffprobe.FfProbe
+	// stop the run once a video fails, running jobs are interrupted
+	StopOnError bool
+	ffmpeg      *ffmpegtranscode.Transcoder
+	ffprobe     ffprobe.FfProbe
+	resources   *resources.Manager
+	stability   *stabilityTracker
+	detector    *mediaDetector
+	recheck     *recheck
 }
 
 // used for testing only
@@ -37,10 +46,20 @@ func New(cfg config.Conf) (*Converter, error) {
 		return nil, err
 	}
 
+	res, err := resources.New(cfg.Resources)
+	if err != nil {
+		return nil, err
+	}
+
+	rc := &recheck{}
 	c := Converter{
-		Cfg:     cfg,
-		ffmpeg:  ffmpeg,
-		ffprobe: fprobe,
+		Cfg:       cfg,
+		ffmpeg:    ffmpeg,
+		ffprobe:   fprobe,
+		resources: res,
+		stability: newStabilityTracker(rc),
+		detector:  newMediaDetector(fprobe.Probe),
+		recheck:   rc,
 	}
 
 	// log level (not sure if I like this here)
@@ -89,6 +108,12 @@ func checkLocation(cfgLocation string, location config.Location, create bool) er
 		location.TmpDir,
 		location.FailDir,
 	}
+	switch location.SourceAction {
+	case config.SourceArchive, config.SourceHardlink:
+		dirs = append(dirs, location.ArchiveDir)
+	case config.SourceTrash:
+		dirs = append(dirs, location.TrashDir)
+	}
 
 	for _, d := range dirs {
 		dir, err := filepath.Abs(filepath.Join(itemPath, d))
@@ -111,58 +136,150 @@ func checkLocation(cfgLocation string, location config.Location, create bool) er
 }
 
 // Run executes the main conversion loop, will exit if not run in daemon mode
-func (vc *Converter) Run() {
+// in daemon mode a new run is started as soon as files are added to any input directory,
+// or after the configured poll interval on filesystems that don't send events
+func (vc *Converter) Run() *RunResult {
+	return vc.RunContext(context.Background())
+}
+
+// RunContext is like Run, but stops once the context is canceled: running ffmpeg processes are
+// terminated, their partial tmp outputs deleted and the source videos are left in the input directory
+func (vc *Converter) RunContext(ctx context.Context) *RunResult {
 	log.Info("starting video conversion...")
+	result := &RunResult{}
+
+	var watcher *dirWatcher
+	if vc.DaemonMode {
+		w, err := newDirWatcher(vc.inputDirs())
+		if err != nil {
+			log.Warnf("unable to watch input directories, polling every %s instead: %v", vc.Cfg.Sleep, err)
+		} else {
+			watcher = w
+			defer func() {
+				_ = watcher.Close()
+			}()
+		}
+	}
+
+	for _, location := range vc.Cfg.Locations {
+		vc.cleanTmpCopies(location)
+	}
+
 	for {
+		vc.stability.newPass()
+		vc.detector.newPass()
+		vc.recheck.reset()
+		result.newPass()
+		var jobs []*job
+		for i, location := range vc.Cfg.Locations {
+			vc.pruneTrash(location)
+			locationJobs, err := vc.locationJobs(i)
+			if err != nil {
+				log.Errorf("skipping location \"%s\": %v", location.Path, err)
+				result.addError(err)
+				continue
+			}
+			jobs = append(jobs, locationJobs...)
+		}
+		vc.runJobs(ctx, jobs, result)
+		if ctx.Err() != nil {
+			log.Info("interrupted, exiting...")
+			break
+		}
 		for _, location := range vc.Cfg.Locations {
-			vc.runLocation(location)
+			vc.tidyInput(location)
+		}
+		if vc.StopOnError && result.Failed > 0 {
+			log.Info("a video failed, exiting...")
+			break
 		}
 		if !vc.DaemonMode {
 			log.Info("finished, exiting...")
 			break
 		}
-		log.Infof("finished, sleeping for %s", vc.Cfg.Sleep)
-		time.Sleep(vc.Cfg.Sleep)
+		// check again once the files waiting to settle or to be retried could be ready
+		poll := vc.Cfg.Sleep
+		if next := vc.recheck.next(); next > 0 && next < poll {
+			poll = next
+		}
+		log.Infof("finished, waiting for new files, polling every %s", poll)
+		watcher.wait(ctx, poll)
 	}
+	return result
 }
 
-// convert Videos on one location
-func (vc *Converter) runLocation(location config.Location) {
+// locationPath returns the absolute path of a location, relative paths are relative to the config file
+func (vc *Converter) locationPath(location config.Location) (string, error) {
+	return filepath.Abs(filepath.Join(filepath.Dir(vc.Cfg.ConfigLocation), location.Path))
+}
+
+// inputDirs returns the absolute input directories of all locations that exist
+func (vc *Converter) inputDirs() []string {
+	var dirs []string
+	for _, location := range vc.Cfg.Locations {
+		locationPath, err := vc.locationPath(location)
+		if err != nil {
+			continue
+		}
+		dir := filepath.Join(locationPath, location.InputDir)
+		if _, err := os.Stat(dir); err != nil {
+			continue
+		}
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+// convert Videos on one location, id is the index of the location in the config
+func (vc *Converter) runLocation(id int) *RunResult {
+	result := &RunResult{}
+	jobs, err := vc.locationJobs(id)
+	if err != nil {
+		result.addError(err)
+		return result
+	}
+	vc.runJobs(context.Background(), jobs, result)
+	return result
+}
+
+// locationJobs searches the input directory of the location with the index id in the config
+// and returns a job for every video found
+func (vc *Converter) locationJobs(id int) ([]*job, error) {
+	location := vc.Cfg.Locations[id]
 	log.Debug("running location:" + location.Path)
-	locationPath, err := filepath.Abs(filepath.Join(filepath.Dir(vc.Cfg.ConfigLocation), location.Path))
+	locationPath, err := vc.locationPath(location)
 	if err != nil {
-		log.Errorf("error generating absolute path for location \"%s\", skipping location, error: %v", locationPath, err)
-		return
+		return nil, fmt.Errorf("error generating absolute path for location \"%s\": %v", location.Path, err)
 	}
 	if _, err := os.Stat(locationPath); os.IsNotExist(err) {
-		log.Errorf("directory %s does not exist or is not accessible, skipping location", locationPath)
-		return
+		return nil, fmt.Errorf("directory %s does not exist or is not accessible", locationPath)
 	}
 
 	err = checkLocation(vc.Cfg.ConfigLocation, location, false)
 	if err != nil {
-		log.Errorf("location contains error:\"%s\", sskipping...", err.Error())
+		return nil, fmt.Errorf("location contains error: %v", err)
 	}
 
-	videos, err := findVideos(filepath.Join(locationPath, location.InputDir), vc.Cfg.VideoExtensions)
+	found, err := scanInput(filepath.Join(locationPath, location.InputDir), newVideoFilter(location, vc.Cfg.VideoExtensions, vc.detector))
 	if err != nil {
-		log.Errorf("error searching for Videos:\"%s\", sskipping...", err.Error())
+		return nil, fmt.Errorf("error searching for videos: %v", err)
 	}
+	sidecars := matchSidecars(found, sidecarPatterns(location), location.UploadSuffixes)
 
-	for _, video := range videos {
-
+	jobs := make([]*job, 0, len(found.videos))
+	for _, video := range found.videos {
 		videoPath := filepath.Join(locationPath, location.InputDir, video)
-		absInPath := filepath.Join(locationPath, location.InputDir)
-		absOutPath := filepath.Join(locationPath, location.OutputDir)
-		absTmpPath := filepath.Join(locationPath, location.TmpDir)
-		absFailPath := filepath.Join(locationPath, location.FailDir)
-
-		if processFn != nil {
-			processFn(videoPath, absInPath, absOutPath, absTmpPath, absFailPath, location.Profiles) // used for testing purposes
-		} else {
-			vc.processVideo(videoPath, absInPath, absOutPath, absTmpPath, absFailPath, location.Profiles)
+		ready, reason := vc.stability.ready(videoPath, location.SettleTime, location.UploadSuffixes, location.ExclusiveCheck)
+		if !ready {
+			log.Infof("skipping video \"%s\" for now: %s", video, reason)
+			continue
 		}
+		j := newJob(location, locationPath, video)
+		j.locationID = id
+		j.sidecars = sidecars[video]
+		jobs = append(jobs, j)
 	}
+	return jobs, nil
 }
 
 func renameFile(in, profileName string, overwriteExtension string) string {
@@ -174,7 +291,9 @@ func renameFile(in, profileName string, overwriteExtension string) string {
 		ext = overwriteExtension
 	}
 	ext = strings.Trim(ext, ".")
-	name = name + "." + ext
+	if ext != "" {
+		name = name + "." + ext
+	}
 	return name
 }
 
@@ -182,175 +301,530 @@ type templateData struct {
 	Init      []string `json:"init"`
 	Args      []string `json:"args"`
 	Extension string   `json:"extension"`
+	// name of the rendition without extension, used if the profile sets none
+	OutputName string `json:"output_name"`
+	// "directory" for outputs made of several files like hls or dash, ffmpeg writes OutputFile into a tmp directory
+	// that is published as a whole
+	OutputMode string `json:"output_mode"`
+	OutputFile string `json:"output_file"`
+	// ffmpeg runs of a multi-pass encode, used instead of args
+	Passes []passData `json:"passes"`
 }
 
+// output modes of the templates
+const (
+	outputModeFile      = "file"
+	outputModeDirectory = "directory"
+)
+
 type videoData struct {
-	Video     ffprobe.ProbeData
-	Profile   map[string]string
-	LocalData map[string]interface{} // used to allow template to allocate data
+	Video        ffprobe.ProbeData
+	Profile      map[string]string
+	Resource     resources.Slot         // resource leased for the profile, empty if none is used
+	Sidecars     sidecarData            // files that belong to the video
+	File         fileData               // the source video
+	Pass         int                    // pass being rendered, starting at 1
+	PassLogFile  string                 // prefix of the pass log files in the tmp dir, e.g. for -passlogfile
+	Measurements map[string]string      // values printed as json on stderr by the previous passes, e.g. by loudnorm
+	LocalData    map[string]interface{} // used to allow template to allocate data
 }
 
 // processVideo is responsible for taking one video and generate all the renditions as per profile configuration
-func (vc *Converter) processVideo(absVideo, absIn, absOut, absTmp, absFail string, profiles []config.Profile) {
-	log.Infof("procesing video: \"%s\"", filepath.Base(absVideo))
-
+// jobs can run in parallel, the tmp files mirror the relative path of the video to avoid collisions
+// between videos with the same name in different directories.
+// The progress is recorded in the journal of the location, so that renditions finished before a crash
+// or a shutdown are not transcoded again.
+// if the context is canceled, the partial tmp files are deleted and the source is left in the input directory.
+// When a profile fails the partial failure policy of the location decides if the other profiles still run
+// and if their renditions are published.
+func (vc *Converter) processVideo(ctx context.Context, j *job) VideoResult {
+	absVideo := j.video
+	j.log.Infof("procesing video: \"%s\"", filepath.Base(absVideo))
+
+	result := VideoResult{
+		Location: j.location.Path,
+		Video:    absVideo,
+		Outcome:  OutcomeDone,
+		Started:  time.Now(),
+	}
 	cmd := ffmpegtranscode.CmdArgs{}
+	var plan VideoPlan
+	var rec *jobRecord
+	// the attempt was counted in the record
+	counted := false
+	jr := newJournal(j.tmp)
 	err := func() error {
 
-		probeData, err := vc.ffprobe.Probe(absVideo)
+		relativePath, err := j.relPath()
 		if err != nil {
-			return fmt.Errorf("unable to run ffprobe on video: %v", err)
+			return err
+		}
+		source, err := os.Stat(absVideo)
+		if err != nil {
+			return err
+		}
+		rec, err = jr.load(relativePath, source)
+		if err != nil {
+			j.log.Warnf("ignoring journal record: %v", err)
+			rec = newRecord(relativePath, source)
+		}
+
+		// failed videos wait for the backoff before being retried
+		if wait := time.Until(rec.NextAttempt); wait > 0 {
+			j.log.Debugf("waiting %s before retrying", wait.Round(time.Second))
+			vc.recheck.after(wait)
+			result.Outcome = OutcomeWaiting
+			return nil
+		}
+		if rec.Status == statusDone {
+			j.log.Debugf("video was already processed, skipping")
+			result.Outcome = OutcomeSkipped
+			return nil
+		}
+		if rec.Status == statusFailed {
+			j.log.Debugf("video failed in a previous run and could not be moved to the fail dir, " +
+				"change the file or delete its journal record to process it again")
+			result.Outcome = OutcomeSkipped
+			result.Error = rec.LastError
+			return nil
 		}
 
-		doneVideos := []string{}
-		for _, profile := range profiles {
+		if rec.Started.IsZero() {
+			rec.Started = time.Now()
+		}
+		rec.Status = statusRunning
+		rec.Attempts++
+		counted = true
+		rec.NextAttempt = time.Time{}
 
-			tmplFile, err := tmpl.FindTemplate(vc.Cfg.TmplDirs, profile.Template)
-			if err != nil {
-				return err
-			}
-			log.Debugf("using template: \"%s\"", tmplFile)
-			profileTmpl, err := tmpl.NewTmplFromFile(tmplFile)
-			if err != nil {
-				return err
+		plan, err = vc.planVideo(j)
+		if err != nil {
+			return err
+		}
+
+		var pending []RenditionPlan
+		for _, r := range plan.Renditions {
+			pr := rec.profile(r.Profile)
+			if pr.isPublished() {
+				j.log.Infof("profile \"%s\" was already published in a previous run, skipping", r.Profile)
+				continue
 			}
-			if profile.Name == "" {
-				return fmt.Errorf("profile name cannot be empty")
+			if pr.isDone(r.TmpFile) {
+				j.log.Infof("profile \"%s\" was already done in a previous run, skipping", r.Profile)
+				continue
 			}
+			pending = append(pending, r)
+		}
 
-			// add ffprobe and profile data into the template
-			data := videoData{
-				Video:     probeData,
-				Profile:   profile.Args,
-				LocalData: map[string]interface{}{},
-			}
-			tmplData := templateData{}
-			err = profileTmpl.ParseJson(data, &tmplData)
+		var failed error
+		for _, g := range groupRenditions(j, pending) {
+			g.duration = videoDuration(plan)
+			var run ffmpegtranscode.CmdArgs
+			run, err = vc.runRenditions(ctx, j, jr, rec, g)
 			if err != nil {
-				return fmt.Errorf("error parsing template: %v", err)
-			}
-			tmplData.Args = dropEmpty(tmplData.Args)
-			tmplData.Init = dropEmpty(tmplData.Init)
-			log.Debugf("rendered template: \"%s\"", tmplData)
-
-			outFileName := renameFile(filepath.Base(absVideo), profile.Name, tmplData.Extension)
-			tmpFilePath := filepath.Join(absTmp, outFileName)
-			// delete a potential tmp output file before starting a new conversion
-			if _, err := os.Stat(tmpFilePath); err == nil {
-				log.Warn("deleting OLD tmp file: " + outFileName)
-				e := os.Remove(tmpFilePath)
-				if e != nil {
-					return fmt.Errorf("unable to delete temp file %s, error: %v ", tmpFilePath, e)
+				cmd = run
+				if ctx.Err() != nil || j.location.PartialFailure == config.PartialAllOrNothing {
+					rec.Remaining = remainingProfiles(plan, rec)
+					saveRecord(j, jr, rec)
+					return err
+				}
+				_, profile := classify(err)
+				j.log.Errorf("profile \"%s\" failed, continuing with the other profiles: %v", profile, err)
+				if failed == nil {
+					failed = err
 				}
 			}
+		}
 
-			cmd, err = vc.ffmpeg.Run(absVideo, tmpFilePath, tmplData.Init, tmplData.Args)
-			if err != nil {
-				return fmt.Errorf("error trancoding video: %v", err)
+		var doneVideos []RenditionPlan
+		for _, r := range plan.Renditions {
+			if rec.profile(r.Profile).isDone(r.TmpFile) {
+				doneVideos = append(doneVideos, r)
 			}
-			log.Debugf("ffmpeg cmd: %s", cmd.String())
-			doneVideos = append(doneVideos, tmpFilePath)
 		}
 
-		relativePath, err := filepath.Rel(absIn, absVideo)
-		if err != nil {
-			return fmt.Errorf("error getting the relative path: %v", err)
+		// don't publish anything once a shutdown has been requested
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		rec.Remaining = remainingProfiles(plan, rec)
+		if failed != nil && j.location.PartialFailure != config.PartialPublishSuccessful {
+			saveRecord(j, jr, rec)
+			return failed
+		}
+
+		// decide about existing files before moving anything, so that a conflict does not leave a half published video
+		var steps []publishStep
+		var stepProfiles []string
+		for _, r := range doneVideos {
+			step, decision, err := resolveConflict(j.location.OnConflict, r.TmpFile, r.OutFile)
+			if err != nil {
+				return conflictErr(r.Profile, err)
+			}
+			if decision != "" {
+				j.log.Infof("%s: %s", filepath.Base(r.OutFile), decision)
+			}
+			steps = append(steps, step)
+			stepProfiles = append(stepProfiles, r.Profile)
 		}
-		// create output directories
-		destPath := filepath.Join(absOut, filepath.Dir(relativePath))
-		if _, err := os.Stat(destPath); os.IsNotExist(err) {
-			err = os.MkdirAll(destPath, 0755)
+		var sourceStep publishStep
+		if failed == nil {
+			sourceStep, err = resolveSource(j, plan)
 			if err != nil {
-				return fmt.Errorf("unable to create folder: %s, error: %v ", destPath, err)
+				return conflictErr("", err)
 			}
 		}
 
 		//move the converted files
-		for _, f := range doneVideos {
-			outFile := filepath.Join(absOut, filepath.Dir(relativePath), filepath.Base(f))
-			//spew.Dump(fmt.Sprintf("move video from %s to %s", f, outFile))
+		for i, step := range steps {
+			err := publish(step)
+			if err != nil {
+				return err
+			}
+			pr := rec.profile(stepProfiles[i])
+			pr.Status = statusPublished
+			pr.OutFile = step.dst
+		}
 
-			err := os.Rename(f, outFile)
+		// with publish-successful the source stays until all profiles are published
+		if failed != nil {
+			saveRecord(j, jr, rec)
+			return failed
+		}
+
+		err = publish(sourceStep)
+		if err != nil {
+			return err
+		}
+		if j.location.SourceAction == config.SourceTrash {
+			// the retention starts when the video is moved to the trash
+			now := time.Now()
+			err = os.Chtimes(sourceStep.dst, now, now)
 			if err != nil {
-				return fmt.Errorf("unable to move file %s to %s, error: %v ", f, outFile, err)
+				j.log.Warnf("unable to update the modification time of the trashed video: %v", err)
 			}
 		}
 
-		outFile := filepath.Join(absOut, filepath.Dir(relativePath), filepath.Base(filepath.Base(absVideo)))
-		err = os.Rename(absVideo, outFile)
+		if keepsSource(j.location) || sourceStep.src == "" {
+			// the sidecars stay next to the source, the record marks the video as done so that it is not processed again
+			rec.Status = statusDone
+			rec.Remaining = nil
+			saveRecord(j, jr, rec)
+			return nil
+		}
+
+		// the files that belong to the video end up next to the renditions
+		moveSidecars(j, j.out)
+
+		// the video is done, the record is not needed anymore
+		err = jr.remove(relativePath)
 		if err != nil {
-			return fmt.Errorf("unable to move file \"%s\", error: %v ", filepath.Base(absVideo), err)
+			j.log.Warnf("unable to delete journal record: %v", err)
 		}
 
 		return nil
 
 	}()
-	if err != nil {
+	switch {
+	case err == nil:
+	case ctx.Err() != nil:
+		j.log.Warnf("interrupted, leaving video \"%s\" in the input directory", filepath.Base(absVideo))
+		removeTmpFiles(j, plan, rec)
+		if counted {
+			// an interrupted attempt, e.g. on shutdown, does not count towards max_attempts
+			rec.Attempts--
+			saveRecord(j, jr, rec)
+		}
+		result.Outcome = OutcomeInterrupted
+	default:
+		var failErr error
+		result.Outcome, failErr = vc.handleFailure(j, jr, plan, rec, cmd, err)
+		result.Error = failErr.Error()
+	}
+	result.Finished = time.Now()
+	return result
+}
 
-		relativePath, err2 := filepath.Rel(absIn, absVideo)
-		if err2 != nil {
-			panic(fmt.Errorf("failure in getting relative path during error handling: %s", err))
+// handleFailure retries the video as per policy or moves it to the fail dir. If the video can not
+// be moved it stays in the input directory and is not processed again until it changes.
+func (vc *Converter) handleFailure(j *job, jr journal, plan VideoPlan, rec *jobRecord, cmd ffmpegtranscode.CmdArgs, err error) (string, error) {
+	if rec != nil {
+		// transient errors are retried as per policy, the video stays in the input directory meanwhile
+		class, profile := classify(err)
+		attempts := rec.Attempts
+		if profile != "" {
+			attempts = rec.profile(profile).Attempts
+		}
+		policy := retryPolicy(j.location, profile)
+		if retry, wait := shouldRetry(policy, class, attempts); retry {
+			j.log.Warnf("attempt %d of %d failed with %s error, retrying in %s: %v", attempts, policy.MaxAttempts, class, wait, err)
+			rec.Status = statusPending
+			rec.NextAttempt = time.Now().Add(wait)
+			rec.LastError = err.Error()
+			saveRecord(j, jr, rec)
+			vc.recheck.after(wait)
+			removeTmpFiles(j, plan, rec)
+			return OutcomeRetry, err
 		}
+	}
+
+	j.log.Errorf("Error transcoding video: \"%s\", %s", filepath.Base(j.video), err)
+	if len(cmd.Slice()) > 0 {
+		j.log.Errorf("command run: %s", cmd.String())
+	}
 
-		log.Errorf("Error transcoding video: \"%s\", %s", relativePath, err)
-		if len(cmd.Slice()) > 0 {
-			log.Errorf("command run: %s", cmd.String())
+	relativePath, relErr := j.relPath()
+	if relErr != nil {
+		return OutcomeFailed, fmt.Errorf("%v, unable to move the video to the fail dir: %v", err, relErr)
+	}
+
+	// create output directories
+	failPath := filepath.Join(j.fail, filepath.Dir(relativePath))
+	if _, statErr := os.Stat(failPath); os.IsNotExist(statErr) {
+		mkErr := os.MkdirAll(failPath, 0755)
+		if mkErr != nil {
+			err = fmt.Errorf("%v, unable to create folder \"%s\": %v", err, failPath, mkErr)
+			j.log.Error(err)
+			markFailed(j, jr, rec, err)
+			return OutcomeFailed, err
 		}
+	}
 
-		// create output directories
-		failPath := filepath.Join(absFail, filepath.Dir(relativePath))
-		if _, err3 := os.Stat(failPath); os.IsNotExist(err3) {
-			err3 = os.MkdirAll(failPath, 0755)
-			if err3 != nil {
-				panic(fmt.Errorf("unable to create folder \"%s\" during error handling, error: %v ", failPath, err3))
-			}
+	// the tmp outputs are of no use once the video is in the fail dir
+	removeTmpFiles(j, plan, nil)
+
+	// move failed video
+	report := newFailureReport(j, plan, rec, cmd, err)
+	failOut := filepath.Join(failPath, filepath.Base(j.video))
+	mvErr := fsutil.Move(j.video, failOut)
+	if mvErr != nil {
+		err = fmt.Errorf("%v, unable to move file to the fail dir: %v", err, mvErr)
+		j.log.Error(err)
+		markFailed(j, jr, rec, err)
+		return OutcomeFailed, err
+	}
+
+	wErr := report.write(failOut + reportSuffix)
+	if wErr != nil {
+		j.log.Warnf("unable to write failure report: %v", wErr)
+	}
+	moveSidecars(j, j.fail)
+
+	rmErr := jr.remove(relativePath)
+	if rmErr != nil {
+		j.log.Warnf("unable to delete journal record: %v", rmErr)
+	}
+	return OutcomeFailed, err
+}
+
+// markFailed records a video that failed but could not be moved to the fail dir, so that it is not
+// processed over and over again
+func markFailed(j *job, jr journal, rec *jobRecord, err error) {
+	if rec == nil {
+		return
+	}
+	rec.Status = statusFailed
+	rec.LastError = err.Error()
+	saveRecord(j, jr, rec)
+}
+
+// runRenditions transcodes a group of renditions with a single ffmpeg run into their tmp files and records
+// the result in the journal; if ffmpeg fails all renditions of the group fail. The last command run is returned.
+func (vc *Converter) runRenditions(ctx context.Context, j *job, jr journal, rec *jobRecord, g renditionGroup) (ffmpegtranscode.CmdArgs, error) {
+	started := time.Now()
+	for _, r := range g.renditions {
+		err := prepareTmp(j, r)
+		if err != nil {
+			return g.cmd, err
 		}
+	}
+	for _, r := range g.renditions {
+		pr := rec.profile(r.Profile)
+		pr.Status = statusRunning
+		pr.Attempts++
+		pr.Started = started
+		pr.Finished = time.Time{}
+		pr.TmpFile = r.TmpFile
+		pr.OutFile = r.OutFile
+		pr.OutSize = 0
+		pr.Error = ""
+	}
+	saveRecord(j, jr, rec)
 
-		if vc.Cfg.LogLevel == "debug" {
-			log.Info("Running in Debug mode, not moving the file")
-			os.Exit(1)
+	cmd, err := vc.transcode(ctx, j, g)
+	finished := time.Now()
+	if err != nil {
+		for _, r := range g.renditions {
+			pr := rec.profile(r.Profile)
+			pr.Finished = finished
+			pr.Status = statusFailed
+			pr.Error = err.Error()
+			if ctx.Err() != nil {
+				// interrupted renditions are run again on the next start, the attempt does not count
+				pr.Status = statusPending
+				pr.Attempts--
+			}
+		}
+		saveRecord(j, jr, rec)
+		var jErr *jobError
+		if errors.As(err, &jErr) {
+			return cmd, err
 		}
+		return cmd, profileErr(config.ErrClassFfmpeg, g.renditions[0].Profile, fmt.Errorf("error trancoding video: %w", err))
+	}
+	j.log.Debugf("ffmpeg cmd: %s", cmd.String())
 
-		// move failed video
-		failOut := filepath.Join(failPath, filepath.Base(absVideo))
-		err = os.Rename(absVideo, failOut)
+	var failed error
+	for _, r := range g.renditions {
+		pr := rec.profile(r.Profile)
+		pr.Finished = finished
+		size, err := outputSize(r)
 		if err != nil {
-			panic(fmt.Errorf("unable to move file \"%s\" to failed location, error: %v ", filepath.Base(absVideo), err))
+			pr.Status = statusFailed
+			pr.Error = err.Error()
+			if failed == nil {
+				failed = err
+			}
+			continue
 		}
-
+		pr.Status = statusDone
+		pr.OutSize = size
+		j.log.Infof("profile \"%s\" done in %s", r.Profile, finished.Sub(started).Round(time.Second))
 	}
+	saveRecord(j, jr, rec)
+	return cmd, failed
 }
 
-// findVideos recursively searches Videos in the rootPath and returns an array of relative paths of Videos
-func findVideos(rootPath string, videoExtensions []string) ([]string, error) {
-	var videos []string
+// transcode runs ffmpeg for a group of renditions and returns the last command run, multi-pass renditions
+// are always alone in their group
+func (vc *Converter) transcode(ctx context.Context, j *job, g renditionGroup) (ffmpegtranscode.CmdArgs, error) {
+	if len(g.renditions) == 1 && g.renditions[0].Passes > 0 {
+		return vc.runPasses(ctx, j, g.renditions[0], g.duration)
+	}
+	_, err := vc.ffmpeg.ExecProgress(ctx, g.cmd, g.duration, logProgress(j, g.label()))
+	return g.cmd, err
+}
 
-	err := filepath.Walk(rootPath, func(fPath string, fInfo os.FileInfo, err error) error {
+// prepareTmp deletes a potential tmp output of a previous run and creates the tmp directory of the rendition
+func prepareTmp(j *job, r RenditionPlan) error {
+	if _, err := os.Stat(r.TmpFile); err == nil {
+		j.log.Warn("deleting OLD tmp file: " + filepath.Base(r.TmpFile))
+		e := os.RemoveAll(r.TmpFile)
+		if e != nil {
+			return profileErr(config.ErrClassIO, r.Profile, fmt.Errorf("unable to delete temp file %s, error: %v ", r.TmpFile, e))
+		}
+	}
 
+	tmpDir := filepath.Dir(r.TmpFile)
+	if r.isDir() {
+		tmpDir = r.TmpFile
+	}
+	if _, err := os.Stat(tmpDir); os.IsNotExist(err) {
+		err = os.MkdirAll(tmpDir, 0755)
 		if err != nil {
-			return err
+			return profileErr(config.ErrClassIO, r.Profile, fmt.Errorf("unable to create folder: %s, error: %v ", tmpDir, err))
 		}
-		if fInfo.IsDir() {
-			return nil
+	}
+	return nil
+}
+
+// outputSize checks that ffmpeg created the output of the rendition and returns its size
+func outputSize(r RenditionPlan) (int64, error) {
+	_, err := os.Stat(r.output())
+	if err != nil {
+		return 0, profileErr(config.ErrClassFfmpeg, r.Profile, fmt.Errorf("ffmpeg did not create the output file: %v", err))
+	}
+	size, err := fsutil.Size(r.TmpFile)
+	if err != nil {
+		return 0, profileErr(config.ErrClassIO, r.Profile, err)
+	}
+	return size, nil
+}
+
+// remainingProfiles returns the profiles of the plan that still need to be transcoded
+func remainingProfiles(plan VideoPlan, rec *jobRecord) []string {
+	var remaining []string
+	for _, r := range plan.Renditions {
+		pr := rec.profile(r.Profile)
+		if pr.Status != statusDone && pr.Status != statusPublished {
+			remaining = append(remaining, r.Profile)
 		}
-		ext := filepath.Ext(fPath)
-		ext = ext[1:]
-		if !isVideo(ext, videoExtensions) {
-			return nil
+	}
+	return remaining
+}
+
+// publish moves the file or directory into the output directory, or deletes it if the existing one is kept
+func publish(step publishStep) error {
+	switch {
+	case step.src == "":
+		return nil
+	case step.discard:
+		err := os.RemoveAll(step.src)
+		if err != nil {
+			return fmt.Errorf("unable to delete file %s, error: %v ", step.src, err)
 		}
+		return nil
+	}
 
-		rel, err := filepath.Rel(rootPath, fPath)
+	destPath := filepath.Dir(step.dst)
+	if _, err := os.Stat(destPath); os.IsNotExist(err) {
+		err = os.MkdirAll(destPath, 0755)
 		if err != nil {
-			return err
+			return fmt.Errorf("unable to create folder: %s, error: %v ", destPath, err)
+		}
+	}
+
+	if step.link {
+		// a previous file is only replaced if the conflict policy allows it
+		if _, err := os.Stat(step.dst); err == nil {
+			err = os.Remove(step.dst)
+			if err != nil {
+				return fmt.Errorf("unable to replace file %s, error: %v ", step.dst, err)
+			}
+		}
+		err := os.Link(step.src, step.dst)
+		if err != nil {
+			return fmt.Errorf("unable to link file %s to %s, error: %v ", step.src, step.dst, err)
 		}
-		videos = append(videos, rel)
 		return nil
-	})
+	}
+
+	move := fsutil.Move
+	if fInfo, err := os.Stat(step.src); err == nil && fInfo.IsDir() {
+		move = fsutil.MoveDir
+	}
+	err := move(step.src, step.dst)
 	if err != nil {
-		return nil, err
+		return fmt.Errorf("unable to move file %s to %s, error: %v ", step.src, step.dst, err)
 	}
+	return nil
+}
+
+// saveRecord persists the job record, failing to write the journal does not stop the job
+// but the progress can not be resumed after a crash
+func saveRecord(j *job, jr journal, rec *jobRecord) {
+	err := jr.save(rec)
+	if err != nil {
+		j.log.Warnf("unable to write journal: %v", err)
+	}
+}
 
-	return videos, nil
+// removeTmpFiles deletes the tmp outputs, files or directories, of the renditions of a plan that are not recorded as done
+func removeTmpFiles(j *job, plan VideoPlan, rec *jobRecord) {
+	for _, r := range plan.Renditions {
+		if rec != nil && rec.profile(r.Profile).isDone(r.TmpFile) {
+			continue
+		}
+		if _, err := os.Stat(r.TmpFile); err != nil {
+			continue
+		}
+		j.log.Infof("deleting tmp file: %s", filepath.Base(r.TmpFile))
+		err := os.RemoveAll(r.TmpFile)
+		if err != nil {
+			j.log.Errorf("unable to delete tmp file %s: %v", r.TmpFile, err)
+		}
+	}
 }
 
 // used to check if file is a video based on extension
@@ -0,0 +1,164 @@
+package videoconv
+
+import (
+	"context"
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// quietPeriod is the time without new filesystem events before a new conversion run is triggered,
+// this avoids starting a run for every single write while a file is being copied
+const quietPeriod = 2 * time.Second
+
+// dirWatcher observes the input directories of all locations and notifies about new files
+// note that some filesystems (e.g. NFS or SMB mounts) never send events, hence waiting
+// always falls back to polling
+type dirWatcher struct {
+	watcher *fsnotify.Watcher
+	notify  chan struct{}
+	done    chan struct{}
+}
+
+// newDirWatcher creates a recursive watcher on all the provided directories
+func newDirWatcher(dirs []string) (*dirWatcher, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	dw := dirWatcher{
+		watcher: w,
+		notify:  make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+
+	for _, d := range dirs {
+		err = dw.addRecursive(d)
+		if err != nil {
+			_ = w.Close()
+			return nil, err
+		}
+	}
+
+	go dw.loop()
+	return &dw, nil
+}
+
+// addRecursive adds a watch to the directory and all its subdirectories
+func (dw *dirWatcher) addRecursive(root string) error {
+	return filepath.Walk(root, func(fPath string, fInfo os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !fInfo.IsDir() {
+			return nil
+		}
+		log.Debugf("watching directory: %s", fPath)
+		return dw.watcher.Add(fPath)
+	})
+}
+
+func (dw *dirWatcher) loop() {
+	for {
+		select {
+		case <-dw.done:
+			return
+		case event, ok := <-dw.watcher.Events:
+			if !ok {
+				return
+			}
+			// removals and renames are mostly caused by ourselves moving the processed files away
+			if event.Op&(fsnotify.Create|fsnotify.Write) == 0 {
+				continue
+			}
+			if event.Op&fsnotify.Create == fsnotify.Create {
+				if fInfo, err := os.Stat(event.Name); err == nil && fInfo.IsDir() {
+					if err := dw.addRecursive(event.Name); err != nil {
+						log.Warnf("unable to watch directory %s: %v", event.Name, err)
+					}
+				}
+			}
+			log.Debugf("filesystem event: %s", event)
+			select {
+			case dw.notify <- struct{}{}:
+			default:
+			}
+		case err, ok := <-dw.watcher.Errors:
+			if !ok {
+				return
+			}
+			log.Warnf("error watching input directories: %v", err)
+		}
+	}
+}
+
+// wait blocks until new files show up in any of the observed directories, the poll interval expires
+// or the context is canceled, calling wait on a nil watcher only polls
+func (dw *dirWatcher) wait(ctx context.Context, poll time.Duration) {
+	timer := time.NewTimer(poll)
+	defer timer.Stop()
+
+	var notify chan struct{}
+	if dw != nil {
+		notify = dw.notify
+	}
+
+	select {
+	case <-ctx.Done():
+		return
+	case <-timer.C:
+		return
+	case <-notify:
+	}
+
+	// wait until the directories are quiet before returning
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-notify:
+		case <-time.After(quietPeriod):
+			return
+		}
+	}
+}
+
+func (dw *dirWatcher) Close() error {
+	close(dw.done)
+	return dw.watcher.Close()
+}
+
+// recheck keeps the shortest time after which the input directories should be checked again,
+// e.g. for videos waiting to settle or to be retried
+type recheck struct {
+	mu sync.Mutex
+	in time.Duration
+}
+
+func (r *recheck) after(d time.Duration) {
+	if d <= 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.in == 0 || d < r.in {
+		r.in = d
+	}
+}
+
+func (r *recheck) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.in = 0
+}
+
+// next returns the time until the next check, 0 if none is needed
+func (r *recheck) next() time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.in
+}
@@ -0,0 +1,47 @@
+package videoconv
+
+import (
+	"context"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDirWatcher(t *testing.T) {
+	log.SetOutput(io.Discard) // discard the log entries
+	tmpDir := t.TempDir()
+
+	dw, err := newDirWatcher([]string{tmpDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = dw.Close()
+	}()
+
+	go func() {
+		// files in newly created subdirectories should trigger events as well
+		nested := filepath.Join(tmpDir, "nested")
+		_ = os.Mkdir(nested, 0755)
+		time.Sleep(100 * time.Millisecond)
+		_ = os.WriteFile(filepath.Join(nested, "video.mkv"), []byte("content"), 0644)
+	}()
+
+	start := time.Now()
+	dw.wait(context.Background(), time.Minute)
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("expected the watcher to return after a filesystem event, but it waited for %s", time.Since(start))
+	}
+}
+
+func TestDirWatcherPoll(t *testing.T) {
+	var dw *dirWatcher
+	start := time.Now()
+	dw.wait(context.Background(), 50*time.Millisecond)
+	if time.Since(start) < 50*time.Millisecond {
+		t.Errorf("expected a nil watcher to wait for the poll interval")
+	}
+}
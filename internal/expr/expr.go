@@ -0,0 +1,473 @@
+package expr
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Expr is a compiled boolean expression like `Video.Summary.Video.H >= 2160 && codec != "hevc"`.
+//
+// Supported are the comparisons == != < <= > >=, the logical operators && || ! and parentheses.
+// Operands are numbers, "strings", true, false and dotted paths that are resolved against the
+// environment passed to Eval: struct fields, map keys and slice indexes, e.g. Video.Streams.0.CodecName
+type Expr struct {
+	src  string
+	root node
+}
+
+// Compile parses the expression
+func Compile(src string) (*Expr, error) {
+	tokens, err := tokenize(src)
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression \"%s\": %v", src, err)
+	}
+	p := parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err == nil && p.pos < len(p.tokens) {
+		err = fmt.Errorf("unexpected \"%s\"", p.tokens[p.pos].val)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid expression \"%s\": %v", src, err)
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Eval evaluates the expression against the environment, the result must be a boolean
+func (e *Expr) Eval(env map[string]interface{}) (bool, error) {
+	v, err := e.root.eval(env)
+	if err != nil {
+		return false, fmt.Errorf("unable to evaluate \"%s\": %v", e.src, err)
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("unable to evaluate \"%s\": result is not a boolean: %v", e.src, v)
+	}
+	return b, nil
+}
+
+// tokens
+
+type tokenKind int
+
+const (
+	tkIdent tokenKind = iota
+	tkNumber
+	tkString
+	tkOp
+)
+
+type token struct {
+	kind tokenKind
+	val  string
+}
+
+var operators = []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")"}
+
+func tokenize(src string) ([]token, error) {
+	var tokens []token
+	rs := []rune(src)
+	for i := 0; i < len(rs); {
+		r := rs[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+
+		case r == '"' || r == '\'':
+			end := i + 1
+			var sb strings.Builder
+			for ; end < len(rs) && rs[end] != r; end++ {
+				if rs[end] == '\\' && end+1 < len(rs) {
+					end++
+				}
+				sb.WriteRune(rs[end])
+			}
+			if end >= len(rs) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			tokens = append(tokens, token{kind: tkString, val: sb.String()})
+			i = end + 1
+
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(rs) && unicode.IsDigit(rs[i+1])):
+			end := i + 1
+			for end < len(rs) && (unicode.IsDigit(rs[end]) || rs[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, token{kind: tkNumber, val: string(rs[i:end])})
+			i = end
+
+		case unicode.IsLetter(r) || r == '_':
+			end := i + 1
+			for end < len(rs) && (unicode.IsLetter(rs[end]) || unicode.IsDigit(rs[end]) || rs[end] == '_' || rs[end] == '.') {
+				end++
+			}
+			tokens = append(tokens, token{kind: tkIdent, val: string(rs[i:end])})
+			i = end
+
+		default:
+			found := false
+			for _, op := range operators {
+				if strings.HasPrefix(string(rs[i:]), op) {
+					tokens = append(tokens, token{kind: tkOp, val: op})
+					i += len([]rune(op))
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("unexpected character '%c'", r)
+			}
+		}
+	}
+	return tokens, nil
+}
+
+// parser
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+func (p *parser) peek(op string) bool {
+	return p.pos < len(p.tokens) && p.tokens[p.pos].kind == tkOp && p.tokens[p.pos].val == op
+}
+
+func (p *parser) parseOr() (node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek("||") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalNode{op: "||", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek("&&") {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = logicalNode{op: "&&", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if p.peek("!") {
+		p.pos++
+		n, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notNode{n: n}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	left, err := p.parsePrimary()
+	if err != nil {
+		return nil, err
+	}
+	for _, op := range []string{"==", "!=", "<=", ">=", "<", ">"} {
+		if p.peek(op) {
+			p.pos++
+			right, err := p.parsePrimary()
+			if err != nil {
+				return nil, err
+			}
+			return compareNode{op: op, left: left, right: right}, nil
+		}
+	}
+	return left, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("unexpected end of expression")
+	}
+	t := p.tokens[p.pos]
+	p.pos++
+	switch t.kind {
+	case tkNumber:
+		f, err := strconv.ParseFloat(t.val, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid number \"%s\"", t.val)
+		}
+		return literalNode{v: f}, nil
+	case tkString:
+		return literalNode{v: t.val}, nil
+	case tkIdent:
+		switch t.val {
+		case "true":
+			return literalNode{v: true}, nil
+		case "false":
+			return literalNode{v: false}, nil
+		}
+		return pathNode{path: strings.Split(t.val, ".")}, nil
+	}
+	if t.val == "(" {
+		n, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if !p.peek(")") {
+			return nil, fmt.Errorf("missing \")\"")
+		}
+		p.pos++
+		return n, nil
+	}
+	return nil, fmt.Errorf("unexpected \"%s\"", t.val)
+}
+
+// nodes
+
+type node interface {
+	eval(env map[string]interface{}) (interface{}, error)
+}
+
+type literalNode struct {
+	v interface{}
+}
+
+func (n literalNode) eval(map[string]interface{}) (interface{}, error) {
+	return n.v, nil
+}
+
+type notNode struct {
+	n node
+}
+
+func (n notNode) eval(env map[string]interface{}) (interface{}, error) {
+	v, err := n.n.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return nil, fmt.Errorf("\"!\" needs a boolean, got: %v", v)
+	}
+	return !b, nil
+}
+
+type logicalNode struct {
+	op          string
+	left, right node
+}
+
+func (n logicalNode) eval(env map[string]interface{}) (interface{}, error) {
+	l, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	lb, ok := l.(bool)
+	if !ok {
+		return nil, fmt.Errorf("\"%s\" needs booleans, got: %v", n.op, l)
+	}
+	// short circuit
+	if (n.op == "&&" && !lb) || (n.op == "||" && lb) {
+		return lb, nil
+	}
+	r, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	rb, ok := r.(bool)
+	if !ok {
+		return nil, fmt.Errorf("\"%s\" needs booleans, got: %v", n.op, r)
+	}
+	return rb, nil
+}
+
+type compareNode struct {
+	op          string
+	left, right node
+}
+
+func (n compareNode) eval(env map[string]interface{}) (interface{}, error) {
+	l, err := n.left.eval(env)
+	if err != nil {
+		return nil, err
+	}
+	r, err := n.right.eval(env)
+	if err != nil {
+		return nil, err
+	}
+
+	// numbers stored as strings, like the ffprobe bit rate, are compared as numbers
+	lf, lNum := toNumber(l)
+	rf, rNum := toNumber(r)
+	_, lStr := l.(string)
+	_, rStr := r.(string)
+	if lNum && rNum && !(lStr && rStr) {
+		return compareNumbers(n.op, lf, rf), nil
+	}
+
+	switch lv := l.(type) {
+	case string:
+		rv, ok := r.(string)
+		if !ok {
+			return nil, fmt.Errorf("unable to compare \"%v\" with %v", l, r)
+		}
+		return compareStrings(n.op, lv, rv), nil
+	case bool:
+		rv, ok := r.(bool)
+		if !ok || (n.op != "==" && n.op != "!=") {
+			return nil, fmt.Errorf("unable to compare %v %s %v", l, n.op, r)
+		}
+		return (lv == rv) == (n.op == "=="), nil
+	}
+	return nil, fmt.Errorf("unable to compare %v %s %v", l, n.op, r)
+}
+
+func toNumber(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func compareNumbers(op string, l, r float64) bool {
+	switch op {
+	case "==":
+		return l == r
+	case "!=":
+		return l != r
+	case "<":
+		return l < r
+	case "<=":
+		return l <= r
+	case ">":
+		return l > r
+	default:
+		return l >= r
+	}
+}
+
+func compareStrings(op string, l, r string) bool {
+	switch op {
+	case "==":
+		return l == r
+	case "!=":
+		return l != r
+	case "<":
+		return l < r
+	case "<=":
+		return l <= r
+	case ">":
+		return l > r
+	default:
+		return l >= r
+	}
+}
+
+type pathNode struct {
+	path []string
+}
+
+func (n pathNode) eval(env map[string]interface{}) (interface{}, error) {
+	v, ok := env[n.path[0]]
+	if !ok {
+		return nil, fmt.Errorf("unknown variable \"%s\"", n.path[0])
+	}
+	rv := reflect.ValueOf(v)
+	for i, name := range n.path[1:] {
+		next, err := field(rv, name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", strings.Join(n.path[:i+2], "."), err)
+		}
+		rv = next
+	}
+	return value(rv, strings.Join(n.path, "."))
+}
+
+// field returns the struct field, map key or slice index of v
+func field(v reflect.Value, name string) (reflect.Value, error) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Value{}, fmt.Errorf("nil value")
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := strings.Split(f.Tag.Get("json"), ",")[0]
+			if f.IsExported() && (strings.EqualFold(f.Name, name) || tag == name) {
+				return v.Field(i), nil
+			}
+		}
+		return reflect.Value{}, fmt.Errorf("unknown field \"%s\"", name)
+
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return reflect.Value{}, fmt.Errorf("unsupported map key")
+		}
+		item := v.MapIndex(reflect.ValueOf(name).Convert(v.Type().Key()))
+		if !item.IsValid() {
+			// missing keys, e.g. absent tags, are empty
+			return reflect.ValueOf(""), nil
+		}
+		return item, nil
+
+	case reflect.Slice, reflect.Array:
+		idx, err := strconv.Atoi(name)
+		if err != nil || idx < 0 {
+			return reflect.Value{}, fmt.Errorf("invalid index \"%s\"", name)
+		}
+		if idx >= v.Len() {
+			return reflect.Value{}, fmt.Errorf("index %d out of range", idx)
+		}
+		return v.Index(idx), nil
+	}
+	return reflect.Value{}, fmt.Errorf("unable to get \"%s\" of %s", name, v.Kind())
+}
+
+// value converts the final value of a path into a float64, string or bool
+func value(v reflect.Value, path string) (interface{}, error) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return "", nil
+		}
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), nil
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), nil
+	case reflect.String:
+		return v.String(), nil
+	case reflect.Bool:
+		return v.Bool(), nil
+	}
+	return nil, fmt.Errorf("%s is a %s and can not be compared", path, v.Kind())
+}
@@ -0,0 +1,106 @@
+package expr
+
+import (
+	"testing"
+)
+
+type stream struct {
+	CodecName string `json:"codec_name"`
+	Height    int
+}
+
+type probe struct {
+	Streams []stream
+	BitRate string
+	Tags    map[string]string
+	Valid   bool
+}
+
+func TestEval(t *testing.T) {
+	env := map[string]interface{}{
+		"Video": probe{
+			Streams: []stream{{CodecName: "h264", Height: 2160}},
+			BitRate: "8000000",
+			Tags:    map[string]string{"language": "eng"},
+			Valid:   true,
+		},
+		"codec":  "h264",
+		"height": 2160,
+	}
+
+	tcs := []struct {
+		expr   string
+		expect bool
+	}{
+		{expr: `height >= 2160`, expect: true},
+		{expr: `height > 2160`, expect: false},
+		{expr: `codec != "hevc"`, expect: true},
+		{expr: `codec == 'hevc'`, expect: false},
+		{expr: `Video.Streams.0.Height == 2160 && Video.Streams.0.codec_name == "h264"`, expect: true},
+		{expr: `Video.BitRate > 4000000`, expect: true},
+		{expr: `Video.Tags.language == "eng"`, expect: true},
+		{expr: `Video.Tags.title == ""`, expect: true},
+		{expr: `Video.Valid`, expect: true},
+		{expr: `!Video.Valid || height < 720`, expect: false},
+		{expr: `(codec == "hevc" || codec == "h264") && !(height < 1080)`, expect: true},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.expr, func(t *testing.T) {
+			e, err := Compile(tc.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := e.Eval(env)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tc.expect {
+				t.Errorf("expected %v, got %v", tc.expect, got)
+			}
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	env := map[string]interface{}{
+		"codec":  "h264",
+		"height": 2160,
+		"Video":  probe{},
+	}
+
+	tcs := []struct {
+		name       string
+		expr       string
+		compileErr bool
+	}{
+		{name: "unterminated string", expr: `codec == "h264`, compileErr: true},
+		{name: "missing parenthesis", expr: `(height > 10`, compileErr: true},
+		{name: "dangling operator", expr: `height >`, compileErr: true},
+		{name: "unknown character", expr: `height % 2`, compileErr: true},
+		{name: "unknown variable", expr: `width > 10`},
+		{name: "unknown field", expr: `Video.Width > 10`},
+		{name: "index out of range", expr: `Video.Streams.0.Height > 10`},
+		{name: "not a boolean", expr: `height`},
+		{name: "string with number", expr: `codec > 10`},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			e, err := Compile(tc.expr)
+			if tc.compileErr {
+				if err == nil {
+					t.Errorf("expected a compile error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = e.Eval(env)
+			if err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
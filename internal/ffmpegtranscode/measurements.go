@@ -0,0 +1,30 @@
+package ffmpegtranscode
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Measurements returns the values of the json objects printed by ffmpeg filters on stderr, e.g. the
+// input_i or target_offset measured by loudnorm with print_format=json. Values are returned as strings,
+// if a key is printed more than once the last value wins.
+func Measurements(stderr string) map[string]string {
+	m := map[string]string{}
+	for i := 0; i < len(stderr); i++ {
+		if stderr[i] != '{' {
+			continue
+		}
+		dec := json.NewDecoder(strings.NewReader(stderr[i:]))
+		dec.UseNumber()
+		obj := map[string]interface{}{}
+		if err := dec.Decode(&obj); err != nil {
+			continue
+		}
+		for k, v := range obj {
+			m[k] = fmt.Sprint(v)
+		}
+		i += int(dec.InputOffset()) - 1
+	}
+	return m
+}
@@ -0,0 +1,43 @@
+package ffmpegtranscode
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"testing"
+)
+
+func TestMeasurements(t *testing.T) {
+	tcs := []struct {
+		name   string
+		stderr string
+		expect map[string]string
+	}{
+		{
+			name: "loudnorm",
+			stderr: "size=N/A time=00:00:10.00 bitrate=N/A speed= 250x\n" +
+				"[Parsed_loudnorm_0 @ 0x55d7c7a0a040] \n{\n\t\"input_i\" : \"-27.61\",\n\t\"input_tp\" : \"-4.47\",\n" +
+				"\t\"normalization_type\" : \"dynamic\",\n\t\"target_offset\" : \"0.58\"\n}\n",
+			expect: map[string]string{
+				"input_i": "-27.61", "input_tp": "-4.47", "normalization_type": "dynamic", "target_offset": "0.58",
+			},
+		},
+		{
+			name:   "numbers and several objects",
+			stderr: "{\"a\": 1.50, \"b\": true}\nsome text {not json}\n{\"b\": \"last\"}",
+			expect: map[string]string{"a": "1.50", "b": "last"},
+		},
+		{
+			name:   "no json",
+			stderr: "frame= 250 fps=0.0 q=-0.0 Lsize=N/A time=00:00:10.00",
+			expect: map[string]string{},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			got := Measurements(tc.stderr)
+			if diff := cmp.Diff(got, tc.expect); diff != "" {
+				t.Errorf("unexpected value (-got +want)\n%s", diff)
+			}
+		})
+	}
+}
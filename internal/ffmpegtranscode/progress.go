@@ -0,0 +1,75 @@
+package ffmpegtranscode
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Progress is a progress report written by ffmpeg with -progress, about twice per second
+type Progress struct {
+	OutTime   time.Duration // position of the output
+	Fps       float64
+	Speed     float64 // times real time, e.g. 2.5 for 2.5x
+	TotalSize int64   // bytes written so far
+	// computed from the duration of the input, zero if it is not known
+	Percent float64
+	ETA     time.Duration
+	// the last report, ffmpeg is done
+	End bool
+}
+
+// ProgressFunc receives the progress reports of a running ffmpeg
+type ProgressFunc func(Progress)
+
+// parseProgress reads the key=value blocks written by ffmpeg -progress until r is closed and calls fn
+// at the end of every block, duration is the duration of the input used to compute percent and ETA
+func parseProgress(r io.Reader, duration time.Duration, fn ProgressFunc) {
+	p := Progress{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		value = strings.TrimSpace(value)
+		switch key {
+		case "out_time_us", "out_time_ms":
+			// out_time_ms is in microseconds as well
+			if us, err := strconv.ParseInt(value, 10, 64); err == nil && us >= 0 {
+				p.OutTime = time.Duration(us) * time.Microsecond
+			}
+		case "fps":
+			p.Fps, _ = strconv.ParseFloat(value, 64)
+		case "speed":
+			p.Speed, _ = strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+		case "total_size":
+			p.TotalSize, _ = strconv.ParseInt(value, 10, 64)
+		case "progress":
+			p.End = value == "end"
+			p.Percent, p.ETA = estimate(p.OutTime, p.Speed, duration)
+			if p.End && duration > 0 {
+				p.Percent, p.ETA = 100, 0
+			}
+			fn(p)
+		}
+	}
+}
+
+// estimate returns the percentage done and the remaining time of an encode at the given speed
+func estimate(outTime time.Duration, speed float64, duration time.Duration) (float64, time.Duration) {
+	if duration <= 0 {
+		return 0, 0
+	}
+	percent := float64(outTime) / float64(duration) * 100
+	if percent > 100 {
+		percent = 100
+	}
+	var eta time.Duration
+	if speed > 0 && outTime < duration {
+		eta = time.Duration(float64(duration-outTime) / speed)
+	}
+	return percent, eta
+}
@@ -0,0 +1,88 @@
+package ffmpegtranscode
+
+import (
+	"context"
+	"github.com/google/go-cmp/cmp"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseProgress(t *testing.T) {
+	tcs := []struct {
+		name     string
+		in       string
+		duration time.Duration
+		expect   []Progress
+	}{
+		{
+			name: "with duration",
+			in: "frame=250\nfps=50.00\nbitrate= 750.2kbits/s\ntotal_size=1048576\nout_time_us=30000000\n" +
+				"out_time_ms=30000000\nout_time=00:00:30.000000\nspeed=2.5x\nprogress=continue\n" +
+				"fps=N/A\ntotal_size=N/A\nout_time_us=N/A\nspeed=N/A\nprogress=continue\n" +
+				"fps=48.50\ntotal_size=4194304\nout_time_us=120000000\nspeed=2.4x\nprogress=end\n",
+			duration: 2 * time.Minute,
+			expect: []Progress{
+				{OutTime: 30 * time.Second, Fps: 50, Speed: 2.5, TotalSize: 1048576, Percent: 25, ETA: 36 * time.Second},
+				// values that are not available yet are zero, the position is kept
+				{OutTime: 30 * time.Second, Percent: 25},
+				{OutTime: 2 * time.Minute, Fps: 48.5, Speed: 2.4, TotalSize: 4194304, Percent: 100, End: true},
+			},
+		},
+		{
+			name:   "unknown duration",
+			in:     "out_time_us=5000000\nspeed=1x\nprogress=end\n",
+			expect: []Progress{{OutTime: 5 * time.Second, Speed: 1, End: true}},
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			var got []Progress
+			parseProgress(strings.NewReader(tc.in), tc.duration, func(p Progress) {
+				got = append(got, p)
+			})
+			if diff := cmp.Diff(got, tc.expect); diff != "" {
+				t.Errorf("unexpected value (-got +want)\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestExecProgress(t *testing.T) {
+	// fake ffmpeg binary that reports its progress on the pipe passed with -progress
+	bin := filepath.Join(t.TempDir(), "ffmpeg")
+	script := "#!/bin/sh\n[ \"$1 $2\" = \"-progress pipe:3\" ] || exit 1\n" +
+		"printf 'out_time_us=5000000\\nspeed=1x\\nprogress=continue\\n' >&3\n" +
+		"printf 'out_time_us=10000000\\nspeed=1x\\nprogress=end\\n' >&3\n" +
+		"echo done >&2\n"
+	err := os.WriteFile(bin, []byte(script), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ffmpeg, err := New(Cfg{FfmpegBin: bin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd, err := ffmpeg.GetCmd("testdata/video.mp4", "output.mp4", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []float64
+	stderr, err := ffmpeg.ExecProgress(context.Background(), cmd, 10*time.Second, func(p Progress) {
+		got = append(got, p.Percent)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(got, []float64{50, 100}); diff != "" {
+		t.Errorf("unexpected value (-got +want)\n%s", diff)
+	}
+	if stderr != "done\n" {
+		t.Errorf("unexpected stderr: %q", stderr)
+	}
+}
@@ -2,13 +2,41 @@ package ffmpegtranscode
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
+// stopTimeout is the time ffmpeg has to exit after being interrupted before it gets killed
+const stopTimeout = 10 * time.Second
+
+// stderrTail is the amount of stderr lines kept when ffmpeg fails
+const stderrTail = 20
+
+// ExecError is returned when ffmpeg exits with an error
+type ExecError struct {
+	ExitCode int
+	// last lines written by ffmpeg to stderr
+	Stderr []string
+	err    error
+}
+
+func (e *ExecError) Error() string {
+	last := ""
+	if len(e.Stderr) > 0 {
+		last = e.Stderr[len(e.Stderr)-1]
+	}
+	return fmt.Sprintf("%v : %s", e.err, last)
+}
+
+func (e *ExecError) Unwrap() error {
+	return e.err
+}
+
 type Transcoder struct {
 	ffmpeg string
 }
@@ -29,19 +57,30 @@ func New(cfg Cfg) (*Transcoder, error) {
 }
 
 type CmdArgs struct {
-	Ffmpeg   string
-	InitArgs []string
-	Input    string
-	Args     []string
-	Output   string
+	Ffmpeg   string   `json:"ffmpeg"`
+	InitArgs []string `json:"init_args"`
+	Input    string   `json:"input"`
+	Args     []string `json:"args"`
+	Output   string   `json:"output"`
+	// additional outputs written by the same ffmpeg run, the input is only decoded once
+	Outputs []OutputArgs `json:"outputs,omitempty"`
+}
+
+// OutputArgs are the args and the file of an additional output
+type OutputArgs struct {
+	Args   []string `json:"args"`
+	Output string   `json:"output"`
 }
 
 func (cmd CmdArgs) String() string {
-	return fmt.Sprintf("%s %s -i \"%s\" %s \"%s\"",
+	s := fmt.Sprintf("%s %s -i \"%s\" %s \"%s\"",
 		cmd.Ffmpeg, strings.Join(cmd.InitArgs, " "), cmd.Input,
 		strings.Join(cmd.Args, " "), cmd.Output,
 	)
-
+	for _, o := range cmd.Outputs {
+		s += fmt.Sprintf(" %s \"%s\"", strings.Join(o.Args, " "), o.Output)
+	}
+	return s
 }
 
 func (cmd CmdArgs) Slice() []string {
@@ -51,9 +90,69 @@ func (cmd CmdArgs) Slice() []string {
 	r = append(r, "-i", cmd.Input)
 	r = append(r, cmd.Args...)
 	r = append(r, cmd.Output)
+	for _, o := range cmd.Outputs {
+		r = append(r, o.Args...)
+		r = append(r, o.Output)
+	}
 	return r
 }
 
+// exclusiveOptions add inputs or apply to the whole ffmpeg run, args using them can not be shared with other
+// outputs: extra inputs would land after the first output and shift the indexes used by -map
+var exclusiveOptions = []string{
+	"-i", "-filter_complex", "-filter_complex_script", "-lavfi", "-y", "-n", "-loglevel", "-v", "-hide_banner",
+	"-nostdin", "-stats", "-nostats", "-stats_period", "-progress", "-report", "-benchmark", "-benchmark_all",
+	"-filter_threads", "-max_error_rate", "-vstats", "-vstats_file",
+}
+
+// exclusiveOption returns the first arg that prevents the args from sharing an ffmpeg run, if any
+func exclusiveOption(args []string) string {
+	for _, a := range args {
+		for _, o := range exclusiveOptions {
+			if a == o {
+				return a
+			}
+		}
+	}
+	return ""
+}
+
+// Merge combines commands on the same input into a single one with several outputs, so that
+// the input is only decoded once. The ffmpeg binary and the init args of all commands must be the same,
+// and their args must not add inputs or set options of the whole run like -filter_complex.
+func Merge(cmds ...CmdArgs) (CmdArgs, error) {
+	if len(cmds) == 0 {
+		return CmdArgs{}, fmt.Errorf("no command to merge")
+	}
+	if len(cmds) > 1 {
+		for _, cmd := range cmds {
+			args := [][]string{cmd.Args}
+			for _, o := range cmd.Outputs {
+				args = append(args, o.Args)
+			}
+			for _, a := range args {
+				if o := exclusiveOption(a); o != "" {
+					return CmdArgs{}, fmt.Errorf("commands using %s in their args can not be merged", o)
+				}
+			}
+		}
+	}
+	merged := cmds[0]
+	merged.Outputs = append([]OutputArgs{}, cmds[0].Outputs...)
+	for _, cmd := range cmds[1:] {
+		if cmd.Ffmpeg != merged.Ffmpeg || cmd.Input != merged.Input {
+			return CmdArgs{}, fmt.Errorf("commands with different inputs can not be merged")
+		}
+		if strings.Join(cmd.InitArgs, "\x00") != strings.Join(merged.InitArgs, "\x00") {
+			return CmdArgs{}, fmt.Errorf("commands with different init args can not be merged: \"%s\" and \"%s\"",
+				strings.Join(merged.InitArgs, " "), strings.Join(cmd.InitArgs, " "))
+		}
+		merged.Outputs = append(merged.Outputs, OutputArgs{Args: cmd.Args, Output: cmd.Output})
+		merged.Outputs = append(merged.Outputs, cmd.Outputs...)
+	}
+	return merged, nil
+}
+
 // GetCmd returns the string of the ffmpeg command that would be executed
 func (tc *Transcoder) GetCmd(input, output string, init, args []string) (CmdArgs, error) {
 	if input == output {
@@ -66,10 +165,13 @@ func (tc *Transcoder) GetCmd(input, output string, init, args []string) (CmdArgs
 		return CmdArgs{}, err
 	}
 
-	// make sure the path is in absolute notation
-	outFile, err := filepath.Abs(output)
-	if err != nil {
-		return CmdArgs{}, err
+	// make sure the path is in absolute notation, "-" writes to stdout, e.g. for analysis passes with "-f null"
+	outFile := output
+	if output != "-" {
+		outFile, err = filepath.Abs(output)
+		if err != nil {
+			return CmdArgs{}, err
+		}
 	}
 
 	r := CmdArgs{
@@ -84,13 +186,38 @@ func (tc *Transcoder) GetCmd(input, output string, init, args []string) (CmdArgs
 }
 
 // Run will execute the ffmpeg command with all the parameters
-func (tc *Transcoder) Run(input, output string, init, args []string) (CmdArgs, error) {
+func (tc *Transcoder) Run(ctx context.Context, input, output string, init, args []string) (CmdArgs, error) {
 
 	cmd, err := tc.GetCmd(input, output, init, args)
 	if err != nil {
 		return CmdArgs{}, err
 	}
+	return cmd, tc.Exec(ctx, cmd)
+}
+
+// Exec executes a command previously generated with GetCmd.
+// If the context is canceled ffmpeg is interrupted, and killed if it does not exit in time,
+// in this case the returned error wraps the context error.
+// If ffmpeg fails the error is an ExecError with the exit code and the end of stderr.
+func (tc *Transcoder) Exec(ctx context.Context, cmd CmdArgs) error {
+	_, err := tc.ExecStderr(ctx, cmd)
+	return err
+}
+
+// ExecStderr is like Exec but returns what ffmpeg wrote to stderr, e.g. to read the measurements of a pass
+func (tc *Transcoder) ExecStderr(ctx context.Context, cmd CmdArgs) (string, error) {
+	return tc.ExecProgress(ctx, cmd, 0, nil)
+}
+
+// ExecProgress is like ExecStderr, and if fn is not nil ffmpeg reports its progress with -progress on a
+// separate pipe, fn is called for every report while ffmpeg runs. duration is the duration of the input,
+// used to compute percent and ETA, it can be zero if it is unknown.
+func (tc *Transcoder) ExecProgress(ctx context.Context, cmd CmdArgs, duration time.Duration, fn ProgressFunc) (string, error) {
 	cmdSlice := cmd.Slice()
+	if fn != nil {
+		// the pipe is the first extra file of the process, fd 3
+		cmdSlice = append([]string{cmdSlice[0], "-progress", "pipe:3"}, cmdSlice[1:]...)
+	}
 	command := exec.Command(cmdSlice[0], cmdSlice[1:]...)
 
 	// set var to get the output
@@ -100,15 +227,73 @@ func (tc *Transcoder) Run(input, output string, init, args []string) (CmdArgs, e
 	// set the output to our variable
 	command.Stdout = &out
 	command.Stderr = &errB
-	err = command.Run()
+
+	var progress *os.File
+	if fn != nil {
+		pr, pw, err := os.Pipe()
+		if err != nil {
+			return "", err
+		}
+		command.ExtraFiles = []*os.File{pw}
+		progress = pr
+	}
+	err := command.Start()
+	// the write end of the progress pipe is only needed by ffmpeg
+	for _, f := range command.ExtraFiles {
+		_ = f.Close()
+	}
 	if err != nil {
+		if progress != nil {
+			_ = progress.Close()
+		}
+		return "", err
+	}
+
+	// the progress is parsed until ffmpeg exits and closes the pipe
+	parsed := make(chan struct{})
+	go func() {
+		if progress != nil {
+			parseProgress(progress, duration, fn)
+			_ = progress.Close()
+		}
+		close(parsed)
+	}()
 
-		lines := errB.String()
-		lines = strings.TrimSpace(lines)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			// ask ffmpeg to stop gracefully first
+			if e := command.Process.Signal(os.Interrupt); e != nil {
+				_ = command.Process.Kill()
+				return
+			}
+			select {
+			case <-done:
+			case <-time.After(stopTimeout):
+				_ = command.Process.Kill()
+			}
+		}
+	}()
+	err = command.Wait()
+	close(done)
+	<-parsed
 
-		lines2 := strings.Split(lines, "\n")
-		return cmd, fmt.Errorf("%v : %s", err, lines2[len(lines2)-1:][0])
+	if ctx.Err() != nil {
+		return errB.String(), fmt.Errorf("ffmpeg interrupted: %w", ctx.Err())
+	}
+	if err != nil {
+		lines := strings.Split(strings.TrimSpace(errB.String()), "\n")
+		if len(lines) > stderrTail {
+			lines = lines[len(lines)-stderrTail:]
+		}
+		return errB.String(), &ExecError{
+			ExitCode: command.ProcessState.ExitCode(),
+			Stderr:   lines,
+			err:      err,
+		}
 	}
 
-	return cmd, nil
+	return errB.String(), nil
 }
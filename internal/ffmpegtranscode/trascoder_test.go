@@ -1,9 +1,13 @@
 package ffmpegtranscode
 
 import (
+	"context"
+	"errors"
 	"github.com/google/go-cmp/cmp"
+	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestGetCmd(t *testing.T) {
@@ -54,14 +58,111 @@ func TestGetCmd(t *testing.T) {
 			if err != nil {
 				t.Fatal(err)
 			}
-			if diff := cmp.Diff(cmd, tc.expect); diff != "" {
+			if diff := cmp.Diff(cmd.Slice(), tc.expect); diff != "" {
 				t.Errorf("unexpected value (-got +want)\n%s", diff)
 			}
 		})
 	}
 }
 
+func TestExecCancel(t *testing.T) {
+	// fake ffmpeg binary that never finishes on its own
+	bin := filepath.Join(t.TempDir(), "ffmpeg")
+	err := os.WriteFile(bin, []byte("#!/bin/sh\nexec sleep 30\n"), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ffmpeg, err := New(Cfg{FfmpegBin: bin})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = ffmpeg.Run(ctx, "testdata/video.mp4", "output.mp4", nil, nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected a context error, got: %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("ffmpeg was not interrupted, took %s", time.Since(start))
+	}
+}
+
+func TestExecError(t *testing.T) {
+	// fake ffmpeg binary that writes more stderr lines than are kept and fails
+	bin := filepath.Join(t.TempDir(), "ffmpeg")
+	script := "#!/bin/sh\nfor i in $(seq 1 30); do echo \"line $i\" >&2; done\nexit 3\n"
+	err := os.WriteFile(bin, []byte(script), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ffmpeg, err := New(Cfg{FfmpegBin: bin})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ffmpeg.Run(context.Background(), "testdata/video.mp4", "output.mp4", nil, nil)
+	var execErr *ExecError
+	if !errors.As(err, &execErr) {
+		t.Fatalf("expected an ExecError, got: %v", err)
+	}
+	if execErr.ExitCode != 3 {
+		t.Errorf("expected exit code 3, got: %d", execErr.ExitCode)
+	}
+	if len(execErr.Stderr) != stderrTail || execErr.Stderr[stderrTail-1] != "line 30" {
+		t.Errorf("unexpected stderr tail: %v", execErr.Stderr)
+	}
+	if err.Error() != "exit status 3 : line 30" {
+		t.Errorf("unexpected error message: %s", err.Error())
+	}
+}
+
 func absPath() string {
 	abs, _ := filepath.Abs("./")
 	return abs
 }
+
+func TestMerge(t *testing.T) {
+	hd := CmdArgs{Ffmpeg: "ffmpeg", InitArgs: []string{"-hwaccel", "vaapi"}, Input: "in.mkv", Args: []string{"-s", "hd720"}, Output: "hd.mkv"}
+	sd := CmdArgs{Ffmpeg: "ffmpeg", InitArgs: []string{"-hwaccel", "vaapi"}, Input: "in.mkv", Args: []string{"-s", "vga"}, Output: "sd.mkv"}
+	audio := CmdArgs{Ffmpeg: "ffmpeg", InitArgs: []string{"-hwaccel", "vaapi"}, Input: "in.mkv", Args: []string{"-vn"}, Output: "audio.m4a"}
+
+	got, err := Merge(hd, sd, audio)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := []string{
+		"ffmpeg", "-hwaccel", "vaapi", "-i", "in.mkv",
+		"-s", "hd720", "hd.mkv",
+		"-s", "vga", "sd.mkv",
+		"-vn", "audio.m4a",
+	}
+	if diff := cmp.Diff(got.Slice(), expect); diff != "" {
+		t.Errorf("unexpected value (-got +want)\n%s", diff)
+	}
+	if len(hd.Outputs) != 0 {
+		t.Errorf("expected the merged commands to be unchanged")
+	}
+
+	// extra inputs would shift the inputs of the other outputs
+	subs := CmdArgs{Ffmpeg: "ffmpeg", InitArgs: []string{"-hwaccel", "vaapi"}, Input: "in.mkv", Args: []string{"-i", "in.srt", "-map", "1"}, Output: "subs.mkv"}
+	_, err = Merge(hd, subs)
+	if err == nil {
+		t.Errorf("expected an error for args with inputs")
+	}
+	complexFilter := CmdArgs{Ffmpeg: "ffmpeg", InitArgs: []string{"-hwaccel", "vaapi"}, Input: "in.mkv", Args: []string{"-filter_complex", "[0:v]split[a][b]"}, Output: "split.mkv"}
+	_, err = Merge(complexFilter, hd)
+	if err == nil {
+		t.Errorf("expected an error for args with a complex filter")
+	}
+
+	sd.InitArgs = nil
+	_, err = Merge(hd, sd)
+	if err == nil {
+		t.Errorf("expected an error for different init args")
+	}
+}
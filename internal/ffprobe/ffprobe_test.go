@@ -154,3 +154,52 @@ var kodakProbe = ProbeData{
 		},
 	},
 }
+
+func TestHasVideo(t *testing.T) {
+	tcs := []struct {
+		name   string
+		in     ProbeData
+		expect bool
+	}{
+		{
+			name:   "video",
+			in:     kodakProbe,
+			expect: true,
+		},
+		{
+			name: "audio with cover art",
+			in: ProbeData{
+				Format: Format{FormatName: "mp3"},
+				Streams: []Stream{
+					{CodecType: "audio"},
+					{CodecType: "video", Disposition: StreamDisposition{AttachedPic: 1}},
+				},
+			},
+			expect: false,
+		},
+		{
+			name: "audio only",
+			in: ProbeData{
+				Format:  Format{FormatName: "flac"},
+				Streams: []Stream{{CodecType: "audio"}},
+			},
+			expect: false,
+		},
+		{
+			name: "still image",
+			in: ProbeData{
+				Format:  Format{FormatName: "webp_pipe"},
+				Streams: []Stream{{CodecType: "video"}},
+			},
+			expect: false,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := tc.in.HasVideo(); got != tc.expect {
+				t.Errorf("expected %v, got %v", tc.expect, got)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package ffprobe
 import (
 	"math"
 	"strconv"
+	"strings"
 )
 
 // ProbeData is the root json data structure returned by an ffprobe.
@@ -38,6 +39,20 @@ func (p *ProbeData) Digest() {
 
 }
 
+// HasVideo checks if the data contains a real video stream, cover art attached
+// to audio files and still images are not considered video
+func (p *ProbeData) HasVideo() bool {
+	if p.Format.FormatName == "image2" || strings.HasSuffix(p.Format.FormatName, "_pipe") {
+		return false
+	}
+	for _, s := range p.Streams {
+		if s.CodecType == CodecTypeVideo && s.Disposition.AttachedPic == 0 {
+			return true
+		}
+	}
+	return false
+}
+
 type Summary struct {
 	Video Video
 }
@@ -0,0 +1,49 @@
+package fsutil
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CleanTmp deletes the hidden copies that an interrupted Move or MoveDir left behind in dir and its
+// subdirectories. A directory that was being replaced is put back if its destination is missing, otherwise
+// it is deleted as well. The deleted paths are returned.
+func CleanTmp(dir string) ([]string, error) {
+	var removed []string
+	err := filepath.Walk(dir, func(fPath string, fInfo os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		name := fInfo.Name()
+		if fPath == dir || !strings.HasPrefix(name, tmpPrefix) {
+			return nil
+		}
+
+		if old := strings.TrimPrefix(name, tmpPrefix+"old-"); old != name && fInfo.IsDir() {
+			dst := filepath.Join(filepath.Dir(fPath), old)
+			if _, err := os.Lstat(dst); err == nil {
+				removed = append(removed, fPath)
+			}
+			err = recoverDir(fPath, dst)
+			if err != nil {
+				return err
+			}
+			return filepath.SkipDir
+		}
+
+		err = os.RemoveAll(fPath)
+		if err != nil {
+			return err
+		}
+		removed = append(removed, fPath)
+		if fInfo.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	return removed, err
+}
@@ -0,0 +1,75 @@
+package fsutil
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+func TestCleanTmp(t *testing.T) {
+	dir := t.TempDir()
+	files := []string{
+		"movie.mkv",
+		"nested/.videoconv-movie.mkv",
+		"nested/.videoconv-movie.hls/index.m3u8",
+		"nested/movie.hls/index.m3u8",
+		"nested/.videoconv-old-movie.hls/index.m3u8",
+		"nested/.videoconv-old-show.hls/index.m3u8",
+		"nested/.hidden.mkv",
+	}
+	for _, f := range files {
+		err := os.MkdirAll(filepath.Join(dir, filepath.Dir(f)), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = os.WriteFile(filepath.Join(dir, f), []byte(f), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := CleanTmp(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		filepath.Join(dir, "nested/.videoconv-movie.hls"),
+		filepath.Join(dir, "nested/.videoconv-movie.mkv"),
+		filepath.Join(dir, "nested/.videoconv-old-movie.hls"),
+	}
+	if diff := cmp.Diff(removed, want); diff != "" {
+		t.Errorf("unexpected value (-got +want)\n%s", diff)
+	}
+
+	// the replaced show.hls is put back, its replacement never made it
+	var got []string
+	err = filepath.Walk(dir, func(fPath string, fInfo os.FileInfo, err error) error {
+		if err != nil || fInfo.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, fPath)
+		got = append(got, rel)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(got)
+	want = []string{
+		"movie.mkv",
+		"nested/.hidden.mkv",
+		"nested/movie.hls/index.m3u8",
+		"nested/show.hls/index.m3u8",
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("unexpected value (-got +want)\n%s", diff)
+	}
+
+	// a missing directory is nothing to clean
+	_, err = CleanTmp(filepath.Join(dir, "missing"))
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,13 @@
+//go:build linux
+
+package fsutil
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// exchangeDirs atomically swaps the paths a and b with renameat2(RENAME_EXCHANGE), it fails on kernels
+// and filesystems without support for it
+func exchangeDirs(a, b string) error {
+	return unix.Renameat2(unix.AT_FDCWD, a, unix.AT_FDCWD, b, unix.RENAME_EXCHANGE)
+}
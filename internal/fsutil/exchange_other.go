@@ -0,0 +1,12 @@
+//go:build !linux
+
+package fsutil
+
+import (
+	"errors"
+)
+
+// exchangeDirs is not supported on this platform, directories are replaced in two steps
+func exchangeDirs(a, b string) error {
+	return errors.New("exchanging directories is not supported on this platform")
+}
@@ -0,0 +1,129 @@
+package fsutil
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// tmpPrefix is used for the hidden name of a file while it is copied into its destination directory
+const tmpPrefix = ".videoconv-"
+
+// rename is replaced in tests to simulate moves across filesystems
+var rename = os.Rename
+
+// Move moves the file src to dst. If both are on different filesystems the file is copied to a hidden
+// name next to dst, synced to disk, verified and renamed to dst before src is deleted, this way dst
+// is never seen half-written.
+func Move(src, dst string) error {
+	err := rename(src, dst)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	fInfo, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !fInfo.Mode().IsRegular() {
+		return fmt.Errorf("unable to move %s across filesystems: not a regular file", src)
+	}
+
+	tmp := filepath.Join(filepath.Dir(dst), tmpPrefix+filepath.Base(dst))
+	sum, err := copyFile(src, tmp, fInfo.Mode().Perm())
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	err = verify(tmp, fInfo.Size(), sum)
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+
+	err = os.Rename(tmp, dst)
+	if err != nil {
+		_ = os.Remove(tmp)
+		return err
+	}
+	syncDir(filepath.Dir(dst))
+
+	return os.Remove(src)
+}
+
+// copyFile copies src into dst and syncs it to disk, the sha256 of the content is returned
+func copyFile(src, dst string, perm os.FileMode) ([]byte, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = in.Close()
+	}()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return nil, err
+	}
+
+	h := sha256.New()
+	_, err = io.Copy(out, io.TeeReader(in, h))
+	if err != nil {
+		_ = out.Close()
+		return nil, fmt.Errorf("unable to copy %s: %v", src, err)
+	}
+	err = out.Sync()
+	if err != nil {
+		_ = out.Close()
+		return nil, fmt.Errorf("unable to sync %s: %v", dst, err)
+	}
+	err = out.Close()
+	if err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// verify reads the copied file back and compares its size and checksum
+func verify(file string, size int64, sum []byte) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("copy of %s is incomplete: expected %d bytes, got %d", file, size, n)
+	}
+	if !bytes.Equal(h.Sum(nil), sum) {
+		return fmt.Errorf("checksum of copy %s does not match the source", file)
+	}
+	return nil
+}
+
+// syncDir persists the directory entry of a renamed file, errors are ignored as
+// not all platforms support syncing directories
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	_ = d.Sync()
+	_ = d.Close()
+}
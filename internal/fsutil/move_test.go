@@ -0,0 +1,88 @@
+package fsutil
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestMove(t *testing.T) {
+	tcs := []struct {
+		name        string
+		crossDevice bool
+	}{
+		{
+			name: "same filesystem",
+		},
+		{
+			name:        "across filesystems",
+			crossDevice: true,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.crossDevice {
+				rename = func(oldpath, newpath string) error {
+					return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+				}
+				defer func() {
+					rename = os.Rename
+				}()
+			}
+
+			dir := t.TempDir()
+			src := filepath.Join(dir, "src.mp4")
+			dst := filepath.Join(dir, "out", "dst.mp4")
+			err := os.MkdirAll(filepath.Dir(dst), 0755)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = os.WriteFile(src, []byte("video content"), 0640)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = Move(src, dst)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if _, err := os.Stat(src); !os.IsNotExist(err) {
+				t.Errorf("expected source to be deleted")
+			}
+			b, err := os.ReadFile(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(b) != "video content" {
+				t.Errorf("unexpected content: %s", string(b))
+			}
+			fInfo, err := os.Stat(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fInfo.Mode().Perm() != 0640 {
+				t.Errorf("unexpected permissions: %v", fInfo.Mode().Perm())
+			}
+
+			// no hidden tmp file is left behind
+			entries, err := os.ReadDir(filepath.Dir(dst))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 {
+				t.Errorf("expected only the moved file in the destination, got %d entries", len(entries))
+			}
+		})
+	}
+}
+
+func TestMoveError(t *testing.T) {
+	dir := t.TempDir()
+	err := Move(filepath.Join(dir, "missing.mp4"), filepath.Join(dir, "dst.mp4"))
+	if !os.IsNotExist(err) {
+		t.Errorf("expected not exist error, got: %v", err)
+	}
+}
@@ -0,0 +1,167 @@
+package fsutil
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// exchange is replaced in tests to simulate platforms without an atomic exchange
+var exchange = exchangeDirs
+
+// MoveDir moves the directory src to dst, an existing dst is replaced. The directory is first moved, or
+// copied if both are on different filesystems, to a hidden name next to dst and then renamed to dst,
+// this way dst is never seen incomplete. On Linux an existing dst is swapped atomically with the new one
+// and deleted afterwards. Where that is not supported dst is first renamed to a hidden .videoconv-old- name,
+// so for a moment there is no dst; if the process dies in between, the next call puts the old one back.
+func MoveDir(src, dst string) error {
+	old := filepath.Join(filepath.Dir(dst), tmpPrefix+"old-"+filepath.Base(dst))
+	err := recoverDir(old, dst)
+	if err != nil {
+		return err
+	}
+
+	fInfo, err := os.Stat(src)
+	if err != nil {
+		return err
+	}
+	if !fInfo.IsDir() {
+		return fmt.Errorf("unable to move %s: not a directory", src)
+	}
+
+	staged := filepath.Join(filepath.Dir(dst), tmpPrefix+filepath.Base(dst))
+	// a staged directory is a leftover of an interrupted move
+	err = os.RemoveAll(staged)
+	if err != nil {
+		return err
+	}
+	copied := false
+	err = rename(src, staged)
+	if err != nil {
+		if !errors.Is(err, syscall.EXDEV) {
+			return err
+		}
+		err = copyDir(src, staged)
+		if err != nil {
+			_ = os.RemoveAll(staged)
+			return err
+		}
+		copied = true
+	}
+	// undo puts the source back in place if dst can not be replaced
+	undo := func() {
+		if copied {
+			_ = os.RemoveAll(staged)
+		} else {
+			_ = rename(staged, src)
+		}
+	}
+
+	replaced := ""
+	if _, err := os.Lstat(dst); err == nil {
+		if exchange(staged, dst) == nil {
+			// the staged name holds the replaced directory now
+			replaced = staged
+		} else {
+			err = os.Rename(dst, old)
+			if err != nil {
+				undo()
+				return fmt.Errorf("unable to replace %s: %v", dst, err)
+			}
+			replaced = old
+		}
+	}
+
+	if replaced != staged {
+		err = os.Rename(staged, dst)
+		if err != nil {
+			if replaced != "" {
+				_ = os.Rename(old, dst)
+			}
+			undo()
+			return err
+		}
+	}
+	syncDir(filepath.Dir(dst))
+
+	if replaced != "" {
+		err = os.RemoveAll(replaced)
+		if err != nil {
+			return fmt.Errorf("unable to delete the replaced directory %s: %v", replaced, err)
+		}
+	}
+	if copied {
+		return os.RemoveAll(src)
+	}
+	return nil
+}
+
+// recoverDir cleans up the directory old that a replacement of dst left behind: it is put back
+// if dst is missing, the replacement was interrupted, or else deleted
+func recoverDir(old, dst string) error {
+	if _, err := os.Lstat(old); err != nil {
+		return nil
+	}
+	if _, err := os.Lstat(dst); os.IsNotExist(err) {
+		err = os.Rename(old, dst)
+		if err != nil {
+			return fmt.Errorf("unable to restore the replaced directory %s: %v", old, err)
+		}
+		return nil
+	}
+	err := os.RemoveAll(old)
+	if err != nil {
+		return fmt.Errorf("unable to delete the replaced directory %s: %v", old, err)
+	}
+	return nil
+}
+
+// copyDir recursively copies the directory src into dst, every file is synced and verified
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(fPath string, fInfo os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, fPath)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+
+		switch {
+		case fInfo.IsDir():
+			return os.MkdirAll(target, fInfo.Mode().Perm())
+		case fInfo.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(fPath)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case fInfo.Mode().IsRegular():
+			sum, err := copyFile(fPath, target, fInfo.Mode().Perm())
+			if err != nil {
+				return err
+			}
+			return verify(target, fInfo.Size(), sum)
+		default:
+			return fmt.Errorf("unable to copy %s: not a regular file", fPath)
+		}
+	})
+}
+
+// Size returns the size of a file, or the total size of the files in a directory
+func Size(path string) (int64, error) {
+	var size int64
+	err := filepath.Walk(path, func(fPath string, fInfo os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fInfo.Mode().IsRegular() {
+			size += fInfo.Size()
+		}
+		return nil
+	})
+	return size, err
+}
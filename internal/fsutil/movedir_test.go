@@ -0,0 +1,180 @@
+package fsutil
+
+import (
+	"errors"
+	"github.com/google/go-cmp/cmp"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestMoveDir(t *testing.T) {
+	tcs := []struct {
+		name        string
+		crossDevice bool
+		existing    bool
+		noExchange  bool
+	}{
+		{
+			name: "same filesystem",
+		},
+		{
+			name:        "across filesystems",
+			crossDevice: true,
+		},
+		{
+			name:     "replace existing",
+			existing: true,
+		},
+		{
+			name:        "replace existing across filesystems",
+			crossDevice: true,
+			existing:    true,
+		},
+		{
+			name:       "replace existing without exchange",
+			existing:   true,
+			noExchange: true,
+		},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.name, func(t *testing.T) {
+			if tc.crossDevice {
+				rename = func(oldpath, newpath string) error {
+					return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: syscall.EXDEV}
+				}
+				defer func() {
+					rename = os.Rename
+				}()
+			}
+			if tc.noExchange {
+				exchange = func(a, b string) error {
+					return errors.New("not supported")
+				}
+				defer func() {
+					exchange = exchangeDirs
+				}()
+			}
+
+			dir := t.TempDir()
+			src := filepath.Join(dir, "tmp", "video.hls")
+			dst := filepath.Join(dir, "out", "video.hls")
+			files := map[string]string{
+				"index.m3u8":   "playlist",
+				"seg-000.ts":   "segment 0",
+				"360p/seg.ts":  "segment 1",
+				"360p/ix.m3u8": "variant",
+			}
+			for f, content := range files {
+				err := os.MkdirAll(filepath.Dir(filepath.Join(src, f)), 0755)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = os.WriteFile(filepath.Join(src, f), []byte(content), 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			err := os.MkdirAll(filepath.Dir(dst), 0755)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.existing {
+				err = os.MkdirAll(dst, 0755)
+				if err != nil {
+					t.Fatal(err)
+				}
+				err = os.WriteFile(filepath.Join(dst, "stale.ts"), []byte("old"), 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			err = MoveDir(src, dst)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			if _, err := os.Stat(src); !os.IsNotExist(err) {
+				t.Errorf("expected source to be deleted")
+			}
+			got := map[string]string{}
+			err = filepath.Walk(dst, func(fPath string, fInfo os.FileInfo, err error) error {
+				if err != nil || fInfo.IsDir() {
+					return err
+				}
+				b, err := os.ReadFile(fPath)
+				if err != nil {
+					return err
+				}
+				rel, _ := filepath.Rel(dst, fPath)
+				got[rel] = string(b)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(got, files); diff != "" {
+				t.Errorf("unexpected value (-got +want)\n%s", diff)
+			}
+
+			// no hidden directory is left behind
+			entries, err := os.ReadDir(filepath.Dir(dst))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 {
+				t.Errorf("expected only the moved directory in the destination, got %d entries", len(entries))
+			}
+
+			size, err := Size(dst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size != 33 {
+				t.Errorf("unexpected size: %d", size)
+			}
+		})
+	}
+}
+
+func TestMoveDirRecover(t *testing.T) {
+	dir := t.TempDir()
+	dst := filepath.Join(dir, "video.hls")
+	old := filepath.Join(dir, ".videoconv-old-video.hls")
+
+	// a replacement interrupted after the old directory was renamed aside
+	err := os.MkdirAll(old, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(old, "index.m3u8"), []byte("old"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the old directory is put back even if the move itself fails
+	err = MoveDir(filepath.Join(dir, "missing"), dst)
+	if !os.IsNotExist(err) {
+		t.Errorf("expected a not exist error, got: %v", err)
+	}
+	b, err := os.ReadFile(filepath.Join(dst, "index.m3u8"))
+	if err != nil || string(b) != "old" {
+		t.Errorf("expected the old directory to be restored, got: %s, %v", b, err)
+	}
+
+	// next to an existing dst it is only a leftover
+	err = os.MkdirAll(old, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = MoveDir(filepath.Join(dir, "missing"), dst)
+	if _, err := os.Stat(old); !os.IsNotExist(err) {
+		t.Errorf("expected the leftover to be deleted")
+	}
+	if _, err := os.Stat(dst); err != nil {
+		t.Errorf("expected dst to be kept: %v", err)
+	}
+}
@@ -0,0 +1,52 @@
+package glob
+
+import (
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tcs := []struct {
+		pattern string
+		name    string
+		expect  bool
+	}{
+		{pattern: "*.trailer.*", name: "movie.trailer.mkv", expect: true},
+		{pattern: "*.trailer.*", name: "extras/movie.trailer.mkv", expect: true},
+		{pattern: "*.trailer.*", name: "movie.mkv", expect: false},
+		{pattern: "**/sample/**", name: "sample/movie.mkv", expect: true},
+		{pattern: "**/sample/**", name: "show/s01/sample/movie.mkv", expect: true},
+		{pattern: "**/sample/**", name: "show/samples/movie.mkv", expect: false},
+		{pattern: "**/@eaDir/**", name: "@eaDir/movie.mkv/SYNOVIDEO_VIDEO_SCREENSHOT.jpg", expect: true},
+		{pattern: "shows/*/*.mkv", name: "shows/a/b.mkv", expect: true},
+		{pattern: "shows/*/*.mkv", name: "shows/a/b/c.mkv", expect: false},
+		{pattern: "shows/**/*.mkv", name: "shows/c.mkv", expect: true},
+		{pattern: "shows/**", name: "movies/c.mkv", expect: false},
+	}
+
+	for _, tc := range tcs {
+		t.Run(tc.pattern+" "+tc.name, func(t *testing.T) {
+			if got := Match(tc.pattern, tc.name); got != tc.expect {
+				t.Errorf("expected %v, got %v", tc.expect, got)
+			}
+		})
+	}
+}
+
+func TestValid(t *testing.T) {
+	if !Valid("**/sample/*.mkv") {
+		t.Errorf("expected valid pattern")
+	}
+	if Valid("movie[.mkv") {
+		t.Errorf("expected invalid pattern")
+	}
+}
+
+func TestQuoteMeta(t *testing.T) {
+	name := "Movie [2020] *final?.mkv"
+	if !Match(QuoteMeta("Movie [2020] *final?")+".*", name) {
+		t.Errorf("expected the quoted name to match")
+	}
+	if Match(QuoteMeta("Movie [2020] *")+".*", name) {
+		t.Errorf("expected the quoted star to only match itself")
+	}
+}
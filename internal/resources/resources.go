@@ -0,0 +1,147 @@
+package resources
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Item is a single resource of a pool, e.g. one GPU, that can be used by Capacity jobs at the same time
+type Item struct {
+	Name     string
+	Device   string
+	Capacity int
+}
+
+// Slot is the resource item leased to a job, it is exposed to the templates
+type Slot struct {
+	Pool   string
+	Name   string
+	Device string
+}
+
+type item struct {
+	Item
+	used int
+}
+
+// Manager keeps track of the resource items in use
+type Manager struct {
+	mu    sync.Mutex
+	pools map[string][]*item
+}
+
+// New creates a resource manager for the provided named pools
+func New(pools map[string][]Item) (*Manager, error) {
+	m := Manager{
+		pools: map[string][]*item{},
+	}
+	for name, items := range pools {
+		if len(items) == 0 {
+			return nil, fmt.Errorf("resource pool \"%s\" does not contain any item", name)
+		}
+		for _, i := range items {
+			if i.Capacity < 1 {
+				return nil, fmt.Errorf("resource \"%s\" in pool \"%s\" needs a capacity of at least 1", i.Name, name)
+			}
+			m.pools[name] = append(m.pools[name], &item{Item: i})
+		}
+	}
+	return &m, nil
+}
+
+// Has returns true if a pool with the given name is managed
+func (m *Manager) Has(pool string) bool {
+	_, ok := m.pools[pool]
+	return ok
+}
+
+// Lease holds one slot of every requested pool until it is released
+type Lease struct {
+	m     *Manager
+	slots map[string]*item
+}
+
+// Slot returns the slot leased for the pool, an empty slot is returned if the pool is not part of the lease
+func (l *Lease) Slot(pool string) Slot {
+	if l == nil {
+		return Slot{}
+	}
+	i, ok := l.slots[pool]
+	if !ok {
+		return Slot{}
+	}
+	return Slot{
+		Pool:   pool,
+		Name:   i.Name,
+		Device: i.Device,
+	}
+}
+
+// Release returns all the slots of the lease to their pools, it is safe to call it on a nil lease
+func (l *Lease) Release() {
+	if l == nil || l.m == nil {
+		return
+	}
+	l.m.mu.Lock()
+	defer l.m.mu.Unlock()
+	for _, i := range l.slots {
+		i.used--
+	}
+	l.slots = nil
+	l.m = nil
+}
+
+// TryAcquire leases one slot of every requested pool, the least used item of each pool is chosen.
+// Either all the slots are leased or none, false is returned if any of the pools is fully in use.
+func (m *Manager) TryAcquire(pools ...string) (*Lease, bool, error) {
+	names := unique(pools)
+	for _, name := range names {
+		if !m.Has(name) {
+			return nil, false, fmt.Errorf("resource pool \"%s\" is not defined", name)
+		}
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	lease := Lease{
+		m:     m,
+		slots: map[string]*item{},
+	}
+	for _, name := range names {
+		var found *item
+		for _, i := range m.pools[name] {
+			if i.used >= i.Capacity {
+				continue
+			}
+			// prefer the item with more free capacity
+			if found == nil || i.Capacity-i.used > found.Capacity-found.used {
+				found = i
+			}
+		}
+		if found == nil {
+			return nil, false, nil
+		}
+		lease.slots[name] = found
+	}
+
+	for _, i := range lease.slots {
+		i.used++
+	}
+	return &lease, true, nil
+}
+
+func unique(in []string) []string {
+	seen := map[string]bool{}
+	var out []string
+	for _, s := range in {
+		if s == "" || seen[s] {
+			continue
+		}
+		seen[s] = true
+		out = append(out, s)
+	}
+	sort.Strings(out)
+	return out
+}
@@ -0,0 +1,78 @@
+package resources
+
+import (
+	"github.com/google/go-cmp/cmp"
+	"testing"
+)
+
+func TestTryAcquire(t *testing.T) {
+	m, err := New(map[string][]Item{
+		"gpu": {
+			{Name: "renderD128", Device: "/dev/dri/renderD128", Capacity: 2},
+			{Name: "renderD129", Device: "/dev/dri/renderD129", Capacity: 1},
+		},
+		"cpu": {
+			{Name: "cpu", Capacity: 1},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// first lease gets the item with the most free capacity
+	l1, ok, err := m.TryAcquire("gpu")
+	if err != nil || !ok {
+		t.Fatalf("expected lease, got: %v, %v", ok, err)
+	}
+	want := Slot{Pool: "gpu", Name: "renderD128", Device: "/dev/dri/renderD128"}
+	if diff := cmp.Diff(l1.Slot("gpu"), want); diff != "" {
+		t.Errorf("unexpected value (-got +want)\n%s", diff)
+	}
+
+	// both items have one free slot, the first one is picked
+	l2, ok, _ := m.TryAcquire("gpu", "cpu")
+	if !ok {
+		t.Fatal("expected lease")
+	}
+	if l2.Slot("gpu").Name != "renderD128" || l2.Slot("cpu").Name != "cpu" {
+		t.Errorf("unexpected lease: %v %v", l2.Slot("gpu"), l2.Slot("cpu"))
+	}
+
+	l3, ok, _ := m.TryAcquire("gpu")
+	if !ok || l3.Slot("gpu").Name != "renderD129" {
+		t.Fatalf("expected lease on renderD129, got: %v", l3.Slot("gpu"))
+	}
+
+	// gpu pool is full, nothing is leased, the cpu pool needs to stay free
+	_, ok, _ = m.TryAcquire("gpu")
+	if ok {
+		t.Fatal("expected gpu pool to be exhausted")
+	}
+	l2.Release()
+	l4, ok, _ := m.TryAcquire("cpu", "gpu")
+	if !ok || l4.Slot("gpu").Name != "renderD128" {
+		t.Fatalf("expected lease after release, got: %v", l4.Slot("gpu"))
+	}
+
+	// unknown pools return an error
+	_, _, err = m.TryAcquire("tpu")
+	if err == nil || err.Error() != "resource pool \"tpu\" is not defined" {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	// empty slot outside the lease
+	if diff := cmp.Diff(l1.Slot("cpu"), Slot{}); diff != "" {
+		t.Errorf("unexpected value (-got +want)\n%s", diff)
+	}
+}
+
+func TestNewValidation(t *testing.T) {
+	_, err := New(map[string][]Item{"gpu": {}})
+	if err == nil {
+		t.Error("expected error for empty pool")
+	}
+	_, err = New(map[string][]Item{"gpu": {{Name: "a", Capacity: 0}}})
+	if err == nil {
+		t.Error("expected error for zero capacity")
+	}
+}
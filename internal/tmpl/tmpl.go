@@ -48,14 +48,40 @@ func isset(name string, data interface{}) bool {
 	return v.FieldByName(name).IsValid()
 }
 
+// funcMap returns the functions available to all templates
+func funcMap() template.FuncMap {
+	funcs := sprig.FuncMap()
+	funcs["isset"] = isset
+	return funcs
+}
+
+// Check parses a single line text template without rendering it
+func Check(text string) error {
+	_, err := template.New("text").Funcs(funcMap()).Parse(text)
+	if err != nil {
+		return fmt.Errorf("unable to parse template: %s", err)
+	}
+	return nil
+}
+
+// Render renders a single line text template, e.g. the name of an output file
+func Render(text string, data any) (string, error) {
+	t, err := template.New("text").Funcs(funcMap()).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("unable to parse template: %s", err)
+	}
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
 func (tmpl Template) ParseJson(data, target any) error {
 	tpl := tmpl.tmplStr
 	tpl = strings.ReplaceAll(tpl, "\n", " ")
 
-	funcMap := sprig.FuncMap()
-	funcMap["isset"] = isset
-
-	t, err := template.New("videoTmpl").Funcs(funcMap).Parse(tpl)
+	t, err := template.New("videoTmpl").Funcs(funcMap()).Parse(tpl)
 	if err != nil {
 		return fmt.Errorf("unable to parse template: %s", err)
 	}
@@ -129,3 +129,21 @@ func TestTemplateArgs(t *testing.T) {
 	}
 
 }
+
+func TestRender(t *testing.T) {
+	data := map[string]interface{}{
+		"Name": "clip",
+		"H":    1080,
+	}
+	got, err := Render(`{{ .Name | upper }} [{{ .H }}p]`, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "CLIP [1080p]" {
+		t.Errorf("unexpected value: %s", got)
+	}
+
+	if err := Check(`{{ .Name `); err == nil {
+		t.Errorf("expected an error for an invalid template")
+	}
+}